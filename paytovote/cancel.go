@@ -0,0 +1,69 @@
+package paytovote
+
+import (
+	"bytes"
+
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/basecoin/types"
+	"github.com/tendermint/go-wire"
+)
+
+func (p2v *P2VPlugin) runTxCancelIssue(store types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
+
+	// Decode tx
+	var tx cancelIssueTx
+	err := wire.ReadBinaryBytes(txBytes, &tx)
+	if err != nil {
+		return abci.ErrBaseEncodingError.AppendLog("Error decoding tx: " + err.Error())
+	}
+
+	// Load P2VIssue
+	p2vIssue, err := getIssue(store, tx.Issue)
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("error loading issue: " + err.Error())
+	}
+
+	//Validate Tx against the cancel policy of the issue
+	switch {
+	case p2vIssue.Cancelled:
+		return abci.ErrUnauthorized.AppendLog("Issue is already cancelled")
+	case !bytes.Equal(ctx.CallerAddress, p2vIssue.Creator):
+		return abci.ErrUnauthorized.AppendLog("Only the creator can cancel an issue")
+	case p2vIssue.CancelDeadline == 0 && p2vIssue.hasVotes():
+		return abci.ErrUnauthorized.AppendLog("Cannot cancel an issue with votes")
+	case p2vIssue.CancelDeadline != 0 && p2v.height > p2vIssue.CancelDeadline:
+		return abci.ErrUnauthorized.AppendLog("Cancel deadline of the issue has passed")
+	}
+
+	votes, err := getVotes(store, IssueVotesKey(tx.Issue))
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("error loading votes: " + err.Error())
+	}
+
+	//Refund all escrowed vote fees out of what the issue holds
+	if p2vIssue.EscrowFees {
+		for _, vote := range votes {
+			if !p2vIssue.EscrowedFees.IsGTE(vote.FeePaid) {
+				return abci.ErrInternalError.AppendLog("Escrowed fees of the issue do not cover the refunds")
+			}
+			p2vIssue.EscrowedFees = p2vIssue.EscrowedFees.Minus(vote.FeePaid)
+			payAccount(store, vote.Voter, vote.FeePaid)
+		}
+	}
+
+	//Delegations for the issue alone can no longer be used
+	for _, delegator := range getIssueDelegators(store, tx.Issue) {
+		if delegate := getDelegate(store, delegator, tx.Issue); len(delegate) > 0 {
+			store.Set(DelegatorsKey(delegate, tx.Issue), nil)
+		}
+		store.Set(DelegationKey(delegator, tx.Issue), nil)
+	}
+	store.Set(IssueDelegatorsKey(tx.Issue), nil)
+
+	// Keep the P2VIssue with its tally and receipts as history, the name
+	//  cannot be used again
+	p2vIssue.Cancelled = true
+	store.Set(IssueKey(tx.Issue), wire.BinaryBytes(p2vIssue))
+	chargeFee(store, ctx, nil)
+	return abci.OK
+}
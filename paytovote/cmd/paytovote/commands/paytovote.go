@@ -1,11 +1,13 @@
 package commands
 
 import (
+	"encoding/hex"
 	"fmt"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
+	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/basecoin-examples/paytovote"
 	bcmd "github.com/tendermint/basecoin/cmd/commands"
 	"github.com/tendermint/basecoin/types"
@@ -16,9 +18,19 @@ const PaytovoteName = "paytovote"
 
 var (
 	//flags
-	issueFlag   string
-	voteFeeFlag string
-	voteForFlag bool
+	issueFlag         string
+	voteFeeFlag       string
+	voteForFlag       bool
+	delegateFlag      string
+	delegateIssueFlag string
+	titleFlag         string
+	descriptionFlag   string
+	urlFlag           string
+	contentHashFlag   string
+	votesVoterFlag    string
+	votesIssueFlag    string
+	stakeFlag         string
+	unstakeFlag       bool
 
 	//commands
 	P2VTxCmd = &cobra.Command{
@@ -32,6 +44,18 @@ var (
 		RunE:  queryIssueCmd,
 	}
 
+	P2VQueryVotesCmd = &cobra.Command{
+		Use:   "p2vVotes",
+		Short: "Query the votes cast by a voter or on an issue",
+		RunE:  queryVotesCmd,
+	}
+
+	P2VQueryStakeCmd = &cobra.Command{
+		Use:   "p2vStake",
+		Short: "Query the coins an address locked as voting stake",
+		RunE:  queryStakeCmd,
+	}
+
 	P2VCreateIssueCmd = &cobra.Command{
 		Use:   "create-issue",
 		Short: "Create an issue which can be voted for",
@@ -43,6 +67,24 @@ var (
 		Short: "Vote for an existing issue",
 		RunE:  voteCmd,
 	}
+
+	P2VCancelIssueCmd = &cobra.Command{
+		Use:   "cancel-issue",
+		Short: "Cancel an issue you created",
+		RunE:  cancelIssueCmd,
+	}
+
+	P2VDelegateCmd = &cobra.Command{
+		Use:   "delegate",
+		Short: "Delegate your voting power to another address",
+		RunE:  delegateCmd,
+	}
+
+	P2VStakeCmd = &cobra.Command{
+		Use:   "stake",
+		Short: "Lock coins as voting stake, or unlock them",
+		RunE:  stakeCmd,
+	}
 )
 
 func init() {
@@ -55,6 +97,10 @@ func init() {
 		issueFlag2Reg,
 		{&voteFeeFlag, "voteFee", "1voteToken",
 			"the fees required to  vote on this new issue, uses the format <amt><coin>,<amt2><coin2>,... (eg: 1gold,2silver,5btc)"},
+		{&titleFlag, "title", "", "title of the new issue (optional)"},
+		{&descriptionFlag, "description", "", "long description of the new issue (optional)"},
+		{&urlFlag, "url", "", "link to an external document describing the new issue (optional)"},
+		{&contentHashFlag, "contentHash", "", "hex encoded hash of the off-chain document (optional)"},
 	}
 
 	voteFlags := []bcmd.Flag2Register{
@@ -62,14 +108,39 @@ func init() {
 		{&voteForFlag, "voteFor", false, "if present vote will be a vote-for, if absent a vote-against"},
 	}
 
+	cancelIssueFlags := []bcmd.Flag2Register{
+		issueFlag2Reg,
+	}
+
+	delegateFlags := []bcmd.Flag2Register{
+		{&delegateFlag, "delegate", "", "address to delegate the voting power to, empty to revoke the delegation"},
+		{&delegateIssueFlag, "issue", "", "name of the issue to delegate for, empty for all issues"},
+	}
+
+	stakeFlags := []bcmd.Flag2Register{
+		{&stakeFlag, "stake", "", "coins to lock as voting stake, uses the format <amt><coin>,<amt2><coin2>,..."},
+		{&unstakeFlag, "unstake", false, "if present the coins are unlocked instead"},
+	}
+
+	queryVotesFlags := []bcmd.Flag2Register{
+		{&votesVoterFlag, "voter", "", "address of the voter to list the votes of"},
+		{&votesIssueFlag, "issue", "", "name of the issue to list the votes of"},
+	}
+
+	bcmd.RegisterFlags(P2VQueryVotesCmd, queryVotesFlags)
 	bcmd.RegisterFlags(P2VCreateIssueCmd, createIssueFlags)
 	bcmd.RegisterFlags(P2VVoteCmd, voteFlags)
+	bcmd.RegisterFlags(P2VCancelIssueCmd, cancelIssueFlags)
+	bcmd.RegisterFlags(P2VDelegateCmd, delegateFlags)
+	bcmd.RegisterFlags(P2VStakeCmd, stakeFlags)
 
 	//register commands
-	P2VTxCmd.AddCommand(P2VCreateIssueCmd, P2VVoteCmd)
+	P2VTxCmd.AddCommand(P2VCreateIssueCmd, P2VVoteCmd, P2VCancelIssueCmd, P2VDelegateCmd, P2VStakeCmd)
 
 	bcmd.RegisterTxSubcommand(P2VTxCmd)
 	bcmd.RegisterQuerySubcommand(P2VQueryIssueCmd)
+	bcmd.RegisterQuerySubcommand(P2VQueryVotesCmd)
+	bcmd.RegisterQuerySubcommand(P2VQueryStakeCmd)
 	bcmd.RegisterStartPlugin(PaytovoteName, func() types.Plugin { return paytovote.New() })
 }
 
@@ -80,9 +151,21 @@ func createIssueCmd(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	contentHash, err := hex.DecodeString(bcmd.StripHex(contentHashFlag))
+	if err != nil {
+		return errors.Errorf("Content hash is invalid hex: %v\n", err)
+	}
+
+	metadata := paytovote.P2VMetadata{
+		Title:       titleFlag,
+		Description: descriptionFlag,
+		URL:         urlFlag,
+		ContentHash: contentHash,
+	}
+
 	createIssueFee := types.Coins{{"issueToken", 1}} //manually set the cost to create a new issue here
 
-	txBytes := paytovote.NewCreateIssueTxBytes(issueFlag, voteFee, createIssueFee)
+	txBytes := paytovote.NewCreateIssueWithMetadataTxBytes(issueFlag, metadata, voteFee, createIssueFee)
 
 	fmt.Println("Issue creation transaction sent")
 	return bcmd.AppTx(PaytovoteName, txBytes)
@@ -101,6 +184,44 @@ func voteCmd(cmd *cobra.Command, args []string) error {
 	return bcmd.AppTx(PaytovoteName, txBytes)
 }
 
+func cancelIssueCmd(cmd *cobra.Command, args []string) error {
+
+	txBytes := paytovote.NewCancelIssueTxBytes(issueFlag)
+
+	fmt.Println("Issue cancellation transaction sent")
+	return bcmd.AppTx(PaytovoteName, txBytes)
+}
+
+func delegateCmd(cmd *cobra.Command, args []string) error {
+
+	// convert delegate address to bytes, empty revokes
+	delegate, err := hex.DecodeString(bcmd.StripHex(delegateFlag))
+	if err != nil {
+		return errors.Errorf("Delegate address is invalid hex: %v\n", err)
+	}
+
+	txBytes := paytovote.NewDelegateTxBytes(delegate, delegateIssueFlag)
+
+	fmt.Println("Delegation transaction sent")
+	return bcmd.AppTx(PaytovoteName, txBytes)
+}
+
+func stakeCmd(cmd *cobra.Command, args []string) error {
+
+	stake, err := types.ParseCoins(stakeFlag)
+	if err != nil {
+		return err
+	}
+
+	txBytes := paytovote.NewStakeTxBytes(stake)
+	if unstakeFlag {
+		txBytes = paytovote.NewUnstakeTxBytes(stake)
+	}
+
+	fmt.Println("Stake transaction sent")
+	return bcmd.AppTx(PaytovoteName, txBytes)
+}
+
 func queryIssueCmd(cmd *cobra.Command, args []string) error {
 
 	//get the parent context
@@ -131,3 +252,93 @@ func queryIssueCmd(cmd *cobra.Command, args []string) error {
 	fmt.Println(string(wire.JSONBytes(p2vIssue)))
 	return nil
 }
+
+//queryOK performs the query and fails unless it returns an OK code
+func queryOK(node string, key []byte) (*abci.ResponseQuery, error) {
+	resp, err := bcmd.Query(node, key)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Code.IsOK() {
+		return nil, errors.Errorf("Query for key (%v) returned non-zero code (%v): %v",
+			string(key), resp.Code, resp.Log)
+	}
+	return resp, nil
+}
+
+func queryVotesCmd(cmd *cobra.Command, args []string) error {
+
+	//get the parent context
+	parentContext := cmd.Parent()
+
+	//generate the key of the requested vote records
+	var votesKey []byte
+	switch {
+	case len(votesVoterFlag) > 0 && len(votesIssueFlag) > 0:
+		return fmt.Errorf("only one of --voter or --issue may be set") //never stack trace
+	case len(votesVoterFlag) > 0:
+		voter, err := hex.DecodeString(bcmd.StripHex(votesVoterFlag))
+		if err != nil {
+			return errors.Errorf("Voter address is invalid hex: %v\n", err)
+		}
+		votesKey = paytovote.VoterVotesKey(voter)
+	case len(votesIssueFlag) > 0:
+		votesKey = paytovote.IssueVotesKey(votesIssueFlag)
+	default:
+		return fmt.Errorf("one of --voter or --issue is required") //never stack trace
+	}
+
+	//query the number of receipts, then each of them
+	node := parentContext.Flag("node").Value.String()
+	resp, err := queryOK(node, votesKey)
+	if err != nil {
+		return err
+	}
+	count, err := paytovote.GetVoteCountFromWire(resp.Value)
+	if err != nil {
+		return err
+	}
+	votes := []paytovote.P2VVoteRecord{}
+	for seq := uint64(0); seq < count; seq++ {
+		resp, err = queryOK(node, paytovote.VoteRecordKey(votesKey, seq))
+		if err != nil {
+			return err
+		}
+		vote, err := paytovote.GetVoteFromWire(resp.Value)
+		if err != nil {
+			return err
+		}
+		votes = append(votes, vote)
+	}
+	fmt.Println(string(wire.JSONBytes(votes)))
+	return nil
+}
+
+func queryStakeCmd(cmd *cobra.Command, args []string) error {
+
+	//get the parent context
+	parentContext := cmd.Parent()
+
+	//get the address, generate stake key
+	if len(args) != 1 {
+		return fmt.Errorf("query command requires an argument ([address])") //never stack trace
+	}
+	addr, err := hex.DecodeString(bcmd.StripHex(args[0]))
+	if err != nil {
+		return errors.Errorf("Stake address is invalid hex: %v\n", err)
+	}
+
+	//perform the query, get response
+	resp, err := queryOK(parentContext.Flag("node").Value.String(), paytovote.StakeKey(addr))
+	if err != nil {
+		return err
+	}
+
+	//get the stake and print it
+	stake, err := paytovote.GetStakeFromWire(resp.Value)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(wire.JSONBytes(stake)))
+	return nil
+}
@@ -0,0 +1,52 @@
+package paytovote
+
+import (
+	"github.com/tendermint/basecoin/types"
+	"github.com/tendermint/go-wire"
+)
+
+const (
+	//SetOption key to choose how delegated votes are weighted
+	OptionDelegationMode = "delegation_mode"
+	//SetOption key for the number of blocks after creation an issue can
+	// still be cancelled, 0 only allows cancelling issues without votes
+	OptionCancelWindow = "cancel_window"
+	//SetOption key to hold the vote fees in escrow, so they can be
+	// refunded if the issue is cancelled (true/false)
+	OptionEscrowFees = "escrow_fees"
+
+	//Each delegator adds one vote to their delegates choice
+	DelegationOneAddress = "one-address"
+	//Each direct voter and delegator counts with the coins they locked
+	// as stake, in the denom of the issue's vote fee
+	DelegationStakeWeighted = "stake-weighted"
+)
+
+//P2VConfig holds the plugin wide settings, set with SetOption
+type P2VConfig struct {
+	DelegationMode string
+	CancelWindow   uint64
+	EscrowFees     bool
+}
+
+func ConfigKey() []byte {
+	return []byte("P2VPlugin,config")
+}
+
+func loadConfig(store types.KVStore) (cfg P2VConfig) {
+	cfgBytes := store.Get(ConfigKey())
+	if len(cfgBytes) > 0 {
+		//a broken config falls back to the defaults
+		if err := wire.ReadBinaryBytes(cfgBytes, &cfg); err != nil {
+			cfg = P2VConfig{}
+		}
+	}
+	if len(cfg.DelegationMode) == 0 {
+		cfg.DelegationMode = DelegationOneAddress
+	}
+	return
+}
+
+func saveConfig(store types.KVStore, cfg P2VConfig) {
+	store.Set(ConfigKey(), wire.BinaryBytes(cfg))
+}
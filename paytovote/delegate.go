@@ -0,0 +1,383 @@
+package paytovote
+
+import (
+	"bytes"
+	"fmt"
+
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/basecoin/types"
+	"github.com/tendermint/go-wire"
+)
+
+//P2VRepresentation records the weight counted for an address on an issue
+// and who cast it, a delegate or, for a direct vote, the address itself. It
+// is kept so the weight can be withdrawn or updated later
+type P2VRepresentation struct {
+	Delegate     []byte
+	VoteTypeByte byte
+	Weight       int64
+}
+
+//DelegationKey stores the delegate of a delegator, an empty issue
+// refers to the delegation for all issues
+func DelegationKey(delegator []byte, issue string) []byte {
+	return []byte(fmt.Sprintf("P2VPlugin,delegation=%X,issue=%v", delegator, issue))
+}
+
+//DelegatorsKey stores the list of all delegators of a delegate
+func DelegatorsKey(delegate []byte, issue string) []byte {
+	return []byte(fmt.Sprintf("P2VPlugin,delegators=%X,issue=%v", delegate, issue))
+}
+
+func RepresentationKey(issue string, delegator []byte) []byte {
+	return []byte(fmt.Sprintf("P2VPlugin,represented=%X,issue=%v", delegator, issue))
+}
+
+//IssueDelegatorsKey stores the list of all delegators with a delegation
+// specific to an issue
+func IssueDelegatorsKey(issue string) []byte {
+	return []byte(fmt.Sprintf("P2VPlugin,issuedelegators,issue=%v", issue))
+}
+
+func DirectVoteKey(issue string, voter []byte) []byte {
+	return []byte(fmt.Sprintf("P2VPlugin,voted=%X,issue=%v", voter, issue))
+}
+
+//CountedIssuesKey stores the list of all issues an address was ever counted
+// on, directly or through a delegate
+func CountedIssuesKey(addr []byte) []byte {
+	return []byte(fmt.Sprintf("P2VPlugin,counted=%X", addr))
+}
+
+///////////////////////////////////////////////////
+
+func getDelegate(store types.KVStore, delegator []byte, issue string) []byte {
+	return store.Get(DelegationKey(delegator, issue))
+}
+
+//the delegate for an issue is the issue specific one if set,
+// otherwise the delegate for all issues
+func effectiveDelegate(store types.KVStore, delegator []byte, issue string) []byte {
+	if delegate := getDelegate(store, delegator, issue); len(delegate) > 0 {
+		return delegate
+	}
+	return getDelegate(store, delegator, "")
+}
+
+func getDelegators(store types.KVStore, delegate []byte, issue string) (delegators [][]byte) {
+	delegatorsBytes := store.Get(DelegatorsKey(delegate, issue))
+	if len(delegatorsBytes) > 0 {
+		if err := wire.ReadBinaryBytes(delegatorsBytes, &delegators); err != nil {
+			return nil
+		}
+	}
+	return
+}
+
+func setDelegators(store types.KVStore, delegate []byte, issue string, delegators [][]byte) {
+	if len(delegators) == 0 {
+		store.Set(DelegatorsKey(delegate, issue), nil)
+		return
+	}
+	store.Set(DelegatorsKey(delegate, issue), wire.BinaryBytes(delegators))
+}
+
+func getRepresentation(store types.KVStore, issue string, delegator []byte) (rep P2VRepresentation, found bool) {
+	repBytes := store.Get(RepresentationKey(issue, delegator))
+	if len(repBytes) == 0 {
+		return rep, false
+	}
+	if err := wire.ReadBinaryBytes(repBytes, &rep); err != nil {
+		return rep, false
+	}
+	return rep, true
+}
+
+//getDirectVote loads the record of the vote the voter cast on the issue themselves
+func getDirectVote(store types.KVStore, issue string, voter []byte) (rep P2VRepresentation, voted bool) {
+	voteBytes := store.Get(DirectVoteKey(issue, voter))
+	if len(voteBytes) == 0 {
+		return rep, false
+	}
+	if err := wire.ReadBinaryBytes(voteBytes, &rep); err != nil {
+		return rep, false
+	}
+	return rep, true
+}
+
+//directVote returns the choice the voter cast on the issue themselves
+func directVote(store types.KVStore, issue string, voter []byte) (voteTypeByte byte, voted bool) {
+	rep, voted := getDirectVote(store, issue, voter)
+	return rep.VoteTypeByte, voted
+}
+
+func hasVotedDirectly(store types.KVStore, issue string, voter []byte) bool {
+	_, voted := directVote(store, issue, voter)
+	return voted
+}
+
+func addDelegatedWeight(p2vIssue *P2VIssue, voteTypeByte byte, weight int64) {
+	switch voteTypeByte {
+	case TypeByteVoteFor:
+		p2vIssue.DelegatedFor += weight
+	case TypeByteVoteAgainst:
+		p2vIssue.DelegatedAgainst += weight
+	}
+}
+
+func addDirectWeight(p2vIssue *P2VIssue, voteTypeByte byte, weight int64) {
+	switch voteTypeByte {
+	case TypeByteVoteFor:
+		p2vIssue.StakeFor += weight
+	case TypeByteVoteAgainst:
+		p2vIssue.StakeAgainst += weight
+	}
+}
+
+//withdraw the weight a delegate cast for the delegator on this issue
+func removeRepresentation(store types.KVStore, p2vIssue *P2VIssue, delegator []byte) {
+	rep, found := getRepresentation(store, p2vIssue.Issue, delegator)
+	if !found {
+		return
+	}
+	addDelegatedWeight(p2vIssue, rep.VoteTypeByte, -rep.Weight)
+	store.Set(RepresentationKey(p2vIssue.Issue, delegator), nil)
+}
+
+//voteWeight is what an address adds to the tally of an issue, the same
+// whether it votes directly or through a delegate. Stake is only counted
+// in the single denom of the vote fee, see runTxCreateIssue
+func voteWeight(store types.KVStore, p2vIssue P2VIssue, addr []byte, cfg P2VConfig) int64 {
+	if cfg.DelegationMode != DelegationStakeWeighted {
+		return 1
+	}
+	if len(p2vIssue.FeePerVote) != 1 {
+		return 0
+	}
+	for _, coin := range getStake(store, addr) {
+		if coin.Denom == p2vIssue.FeePerVote[0].Denom {
+			return coin.Amount
+		}
+	}
+	return 0
+}
+
+//directWeight is the weight of a direct voter counted in StakeFor or
+// StakeAgainst, without stake weighting every paid vote already counts
+// as one in VotesFor or VotesAgainst
+func directWeight(store types.KVStore, p2vIssue P2VIssue, voter []byte, cfg P2VConfig) int64 {
+	if cfg.DelegationMode != DelegationStakeWeighted {
+		return 0
+	}
+	return voteWeight(store, p2vIssue, voter, cfg)
+}
+
+//the choice is kept so delegations made after the vote can follow it
+func markVotedDirectly(store types.KVStore, p2vIssue *P2VIssue, voter []byte, voteTypeByte byte, cfg P2VConfig) {
+	removeRepresentation(store, p2vIssue, voter)
+	if old, voted := getDirectVote(store, p2vIssue.Issue, voter); voted {
+		addDirectWeight(p2vIssue, old.VoteTypeByte, -old.Weight)
+	}
+	rep := P2VRepresentation{
+		Delegate:     voter,
+		VoteTypeByte: voteTypeByte,
+		Weight:       directWeight(store, *p2vIssue, voter, cfg),
+	}
+	addDirectWeight(p2vIssue, voteTypeByte, rep.Weight)
+	store.Set(DirectVoteKey(p2vIssue.Issue, voter), wire.BinaryBytes(rep))
+	addCountedIssue(store, voter, p2vIssue.Issue)
+}
+
+//followDelegate counts the delegator on the issue with the choice of their
+// current delegate, or withdraws their weight if that delegate has not voted
+// on the issue. A delegator who voted themselves is left alone.
+func followDelegate(store types.KVStore, p2vIssue *P2VIssue, delegator []byte, cfg P2VConfig) {
+	if hasVotedDirectly(store, p2vIssue.Issue, delegator) {
+		return
+	}
+	delegate := effectiveDelegate(store, delegator, p2vIssue.Issue)
+	voteTypeByte, voted := directVote(store, p2vIssue.Issue, delegate)
+	if len(delegate) == 0 || !voted {
+		removeRepresentation(store, p2vIssue, delegator)
+		return
+	}
+	rep, found := getRepresentation(store, p2vIssue.Issue, delegator)
+	if found && bytes.Equal(rep.Delegate, delegate) && rep.VoteTypeByte == voteTypeByte {
+		return //already counted
+	}
+	removeRepresentation(store, p2vIssue, delegator)
+
+	rep = P2VRepresentation{
+		Delegate:     delegate,
+		VoteTypeByte: voteTypeByte,
+		Weight:       voteWeight(store, *p2vIssue, delegator, cfg),
+	}
+	addDelegatedWeight(p2vIssue, voteTypeByte, rep.Weight)
+	store.Set(RepresentationKey(p2vIssue.Issue, delegator), wire.BinaryBytes(rep))
+	addCountedIssue(store, delegator, p2vIssue.Issue)
+}
+
+//countDelegators casts the votes of all delegators of the delegate for the
+// issue, who have not voted themselves, with the same choice as the
+// delegate. Delegation is not transitive, only direct delegators count.
+func countDelegators(store types.KVStore, p2vIssue *P2VIssue, delegate []byte, cfg P2VConfig) {
+	issueDelegators := getDelegators(store, delegate, p2vIssue.Issue)
+	delegators := append(issueDelegators, getDelegators(store, delegate, "")...)
+
+	for _, delegator := range delegators {
+		//an issue specific delegation overrides the one for all issues
+		if bytes.Equal(effectiveDelegate(store, delegator, p2vIssue.Issue), delegate) {
+			followDelegate(store, p2vIssue, delegator, cfg)
+		}
+	}
+}
+
+//reweigh updates the weight counted for the address on the issue, after
+// their stake changed
+func reweigh(store types.KVStore, p2vIssue *P2VIssue, addr []byte, cfg P2VConfig) {
+	if rep, voted := getDirectVote(store, p2vIssue.Issue, addr); voted {
+		weight := directWeight(store, *p2vIssue, addr, cfg)
+		addDirectWeight(p2vIssue, rep.VoteTypeByte, weight-rep.Weight)
+		rep.Weight = weight
+		store.Set(DirectVoteKey(p2vIssue.Issue, addr), wire.BinaryBytes(rep))
+		return
+	}
+	if rep, found := getRepresentation(store, p2vIssue.Issue, addr); found {
+		weight := voteWeight(store, *p2vIssue, addr, cfg)
+		addDelegatedWeight(p2vIssue, rep.VoteTypeByte, weight-rep.Weight)
+		rep.Weight = weight
+		store.Set(RepresentationKey(p2vIssue.Issue, addr), wire.BinaryBytes(rep))
+	}
+}
+
+//forCountedIssues runs update on every open issue in the lists of counted
+// issues of the addresses and saves the issue
+func forCountedIssues(store types.KVStore, addrs [][]byte, update func(p2vIssue *P2VIssue)) {
+	done := make(map[string]bool)
+	for _, addr := range addrs {
+		for _, issue := range getAddrList(store, CountedIssuesKey(addr)) {
+			if done[string(issue)] {
+				continue
+			}
+			done[string(issue)] = true
+			p2vIssue, err := getIssue(store, string(issue))
+			if err != nil || p2vIssue.Cancelled {
+				continue //the tally of a cancelled issue is final
+			}
+			update(&p2vIssue)
+			store.Set(IssueKey(p2vIssue.Issue), wire.BinaryBytes(p2vIssue))
+		}
+	}
+}
+
+//getAddrList loads a list of addresses stored under key
+func getAddrList(store types.KVStore, key []byte) (addrs [][]byte) {
+	addrsBytes := store.Get(key)
+	if len(addrsBytes) > 0 {
+		if err := wire.ReadBinaryBytes(addrsBytes, &addrs); err != nil {
+			return nil
+		}
+	}
+	return
+}
+
+//addToAddrList appends addr to the list under key unless already present
+func addToAddrList(store types.KVStore, key []byte, addr []byte) {
+	addrs := getAddrList(store, key)
+	for _, listed := range addrs {
+		if bytes.Equal(listed, addr) {
+			return
+		}
+	}
+	store.Set(key, wire.BinaryBytes(append(addrs, addr)))
+}
+
+//addCountedIssue remembers the issue, so changes of the delegation for all
+// issues or of the stake of the address can be applied to its tally
+func addCountedIssue(store types.KVStore, addr []byte, issue string) {
+	addToAddrList(store, CountedIssuesKey(addr), []byte(issue))
+}
+
+func getIssueDelegators(store types.KVStore, issue string) [][]byte {
+	return getAddrList(store, IssueDelegatorsKey(issue))
+}
+
+//addIssueDelegator remembers every delegator who ever delegated for the
+// issue alone, so their delegations can be cleared if it is cancelled
+func addIssueDelegator(store types.KVStore, issue string, delegator []byte) {
+	addToAddrList(store, IssueDelegatorsKey(issue), delegator)
+}
+
+///////////////////////////////////////////////////
+
+func (p2v *P2VPlugin) runTxDelegate(store types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
+
+	// Decode tx
+	var tx delegateTx
+	err := wire.ReadBinaryBytes(txBytes, &tx)
+	if err != nil {
+		return abci.ErrBaseEncodingError.AppendLog("Error decoding tx: " + err.Error())
+	}
+
+	//Validate Tx
+	switch {
+	case len(tx.Delegate) != 0 && len(tx.Delegate) != 20:
+		return abci.ErrBaseInvalidInput.AppendLog("P2VTx.Delegate must be empty or a 20 byte address")
+	case bytes.Equal(tx.Delegate, ctx.CallerAddress):
+		return abci.ErrBaseInvalidInput.AppendLog("Cannot delegate to yourself")
+	}
+
+	//Issue specific delegations require the issue to exist
+	var p2vIssue P2VIssue
+	if len(tx.Issue) > 0 {
+		p2vIssue, err = getIssue(store, tx.Issue)
+		if err != nil {
+			return abci.ErrInternalError.AppendLog("error loading issue: " + err.Error())
+		}
+		if p2vIssue.Cancelled {
+			return abci.ErrUnauthorized.AppendLog("Cannot delegate for a cancelled issue")
+		}
+	}
+
+	//Remove the caller from their previous delegate
+	oldDelegate := getDelegate(store, ctx.CallerAddress, tx.Issue)
+	if len(oldDelegate) > 0 {
+		delegators := getDelegators(store, oldDelegate, tx.Issue)
+		for i, delegator := range delegators {
+			if bytes.Equal(delegator, ctx.CallerAddress) {
+				delegators = append(delegators[:i], delegators[i+1:]...)
+				break
+			}
+		}
+		setDelegators(store, oldDelegate, tx.Issue, delegators)
+	}
+
+	if len(tx.Delegate) == 0 {
+		store.Set(DelegationKey(ctx.CallerAddress, tx.Issue), nil)
+	} else {
+		store.Set(DelegationKey(ctx.CallerAddress, tx.Issue), tx.Delegate)
+		delegators := getDelegators(store, tx.Delegate, tx.Issue)
+		setDelegators(store, tx.Delegate, tx.Issue, append(delegators, ctx.CallerAddress))
+		if len(tx.Issue) > 0 {
+			addIssueDelegator(store, tx.Issue, ctx.CallerAddress)
+		}
+	}
+
+	//The weight of the caller follows the new delegate right away, on the
+	// issue or, for all issues, on every issue the caller was counted on
+	// and every issue the new delegate voted on
+	cfg := loadConfig(store)
+	follow := func(p2vIssue *P2VIssue) {
+		followDelegate(store, p2vIssue, ctx.CallerAddress, cfg)
+	}
+	if len(tx.Issue) > 0 {
+		follow(&p2vIssue)
+		store.Set(IssueKey(tx.Issue), wire.BinaryBytes(p2vIssue))
+	} else {
+		forCountedIssues(store, [][]byte{ctx.CallerAddress, tx.Delegate}, follow)
+	}
+
+	//Delegation is free, return all coins
+	chargeFee(store, ctx, nil)
+	return abci.OK
+}
@@ -2,6 +2,7 @@ package paytovote
 
 import (
 	"fmt"
+	"strconv"
 
 	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/basecoin/state"
@@ -10,7 +11,8 @@ import (
 )
 
 type P2VPlugin struct {
-	name string
+	name   string
+	height uint64
 }
 
 func New() *P2VPlugin {
@@ -22,17 +24,36 @@ func New() *P2VPlugin {
 ///////////////////////////////////////////////////
 
 const (
-	TypeByteTxCreate byte = 0x01
-	TypeByteTxVote   byte = 0x02
+	TypeByteTxCreate   byte = 0x01
+	TypeByteTxVote     byte = 0x02
+	TypeByteTxDelegate byte = 0x03
+	TypeByteTxCancel   byte = 0x04
+	TypeByteTxStake    byte = 0x05
+	TypeByteTxUnstake  byte = 0x06
 
 	TypeByteVoteFor     byte = 0x01
 	TypeByteVoteAgainst byte = 0x02
+
+	//Size limits of the optional issue metadata
+	MaxTitleLength       = 140
+	MaxDescriptionLength = 4096
+	MaxURLLength         = 512
+	MaxContentHashLength = 64
 )
 
+//P2VMetadata optionally describes an issue beyond its name
+type P2VMetadata struct {
+	Title       string
+	Description string
+	URL         string //Link to an external document
+	ContentHash []byte //Hash of the off-chain document
+}
+
 type createIssueTx struct {
 	Issue           string      //Issue to be created
 	FeePerVote      types.Coins //Cost to vote for the issue
 	Fee2CreateIssue types.Coins //Cost to create a new issue
+	Metadata        P2VMetadata //Optional description of the issue
 }
 
 type voteTx struct {
@@ -40,12 +61,30 @@ type voteTx struct {
 	VoteTypeByte byte   //How is the vote being cast
 }
 
+type cancelIssueTx struct {
+	Issue string //Issue to be cancelled, only by its creator
+}
+
+type stakeTx struct {
+	Coins types.Coins //Coins to lock as voting stake, or to unlock
+}
+
+type delegateTx struct {
+	Delegate []byte //Address receiving the voting power, empty to revoke
+	Issue    string //Issue the delegation applies to, empty for all issues
+}
+
 func NewCreateIssueTxBytes(issue string, feePerVote, fee2CreateIssue types.Coins) []byte {
+	return NewCreateIssueWithMetadataTxBytes(issue, P2VMetadata{}, feePerVote, fee2CreateIssue)
+}
+
+func NewCreateIssueWithMetadataTxBytes(issue string, metadata P2VMetadata, feePerVote, fee2CreateIssue types.Coins) []byte {
 	data := wire.BinaryBytes(
 		createIssueTx{
 			Issue:           issue,
 			FeePerVote:      feePerVote,
 			Fee2CreateIssue: fee2CreateIssue,
+			Metadata:        metadata,
 		})
 	data = append([]byte{TypeByteTxCreate}, data...)
 	return data
@@ -61,24 +100,76 @@ func NewVoteTxBytes(issue string, voteTypeByte byte) []byte {
 	return data
 }
 
+func NewCancelIssueTxBytes(issue string) []byte {
+	data := wire.BinaryBytes(
+		cancelIssueTx{
+			Issue: issue,
+		})
+	data = append([]byte{TypeByteTxCancel}, data...)
+	return data
+}
+
+func NewDelegateTxBytes(delegate []byte, issue string) []byte {
+	data := wire.BinaryBytes(
+		delegateTx{
+			Delegate: delegate,
+			Issue:    issue,
+		})
+	data = append([]byte{TypeByteTxDelegate}, data...)
+	return data
+}
+
+func NewStakeTxBytes(coins types.Coins) []byte {
+	data := wire.BinaryBytes(stakeTx{Coins: coins})
+	data = append([]byte{TypeByteTxStake}, data...)
+	return data
+}
+
+func NewUnstakeTxBytes(coins types.Coins) []byte {
+	data := wire.BinaryBytes(stakeTx{Coins: coins})
+	data = append([]byte{TypeByteTxUnstake}, data...)
+	return data
+}
+
 ///////////////////////////////////////////////////
 
 type P2VIssue struct {
-	Issue        string
-	FeePerVote   types.Coins
-	VotesFor     int
-	VotesAgainst int
+	Issue            string
+	FeePerVote       types.Coins
+	VotesFor         int
+	VotesAgainst     int
+	DelegatedFor     int64 //Weight cast by delegates on behalf of their delegators
+	DelegatedAgainst int64
+	StakeFor         int64 //Stake of the direct voters, only with stake weighted delegation
+	StakeAgainst     int64
+	Metadata         P2VMetadata
+	Creator          []byte
+	CancelDeadline   uint64      //Last height to cancel the issue, 0 for only without votes
+	EscrowFees       bool        //Are vote fees held to refund them on cancellation
+	EscrowedFees     types.Coins //Vote fees held for refunds on cancellation
+	Cancelled        bool        //Cancelled issues keep their history but take no votes
 }
 
-func newP2VIssue(issue string, feePerVote types.Coins) P2VIssue {
+func newP2VIssue(issue string, metadata P2VMetadata, feePerVote types.Coins) P2VIssue {
 	return P2VIssue{
-		Issue:        issue,
-		FeePerVote:   feePerVote,
-		VotesFor:     0,
-		VotesAgainst: 0,
+		Issue:            issue,
+		Metadata:         metadata,
+		FeePerVote:       feePerVote,
+		VotesFor:         0,
+		VotesAgainst:     0,
+		DelegatedFor:     0,
+		DelegatedAgainst: 0,
+		StakeFor:         0,
+		StakeAgainst:     0,
 	}
 }
 
+func (p2vIssue P2VIssue) hasVotes() bool {
+	return p2vIssue.VotesFor != 0 || p2vIssue.VotesAgainst != 0 ||
+		p2vIssue.DelegatedFor != 0 || p2vIssue.DelegatedAgainst != 0 ||
+		p2vIssue.StakeFor != 0 || p2vIssue.StakeAgainst != 0
+}
+
 func IssueKey(issue string) []byte {
 	//The state key is defined as only being affected by effected issue
 	// aka. if multiple paytovote plugins are initialized
@@ -114,19 +205,56 @@ func (p2v *P2VPlugin) Name() string {
 }
 
 func (p2v *P2VPlugin) SetOption(store types.KVStore, key string, value string) (log string) {
-	return ""
+	switch key {
+	case OptionDelegationMode:
+		switch value {
+		case DelegationOneAddress, DelegationStakeWeighted:
+		default:
+			return fmt.Sprintf("Unknown delegation mode: %s", value)
+		}
+		cfg := loadConfig(store)
+		cfg.DelegationMode = value
+		saveConfig(store, cfg)
+		return fmt.Sprintf("Delegation mode set to: %s", value)
+	case OptionCancelWindow:
+		window, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Sprintf("Invalid cancel window: %s: %v", value, err)
+		}
+		cfg := loadConfig(store)
+		cfg.CancelWindow = window
+		saveConfig(store, cfg)
+		return fmt.Sprintf("Cancel window set to: %v", window)
+	case OptionEscrowFees:
+		escrow, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Sprintf("Invalid escrow fees: %s: %v", value, err)
+		}
+		cfg := loadConfig(store)
+		cfg.EscrowFees = escrow
+		saveConfig(store, cfg)
+		return fmt.Sprintf("Escrow fees set to: %v", escrow)
+	default:
+		return fmt.Sprintf("Unknown key: %s", key)
+	}
 }
 
 func (p2v *P2VPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
 
-	defer func() {
-		//Return the ctx coins to the wallet if there is an error
-		if res.IsErr() {
-			acc := ctx.CallerAccount
-			acc.Balance = acc.Balance.Plus(ctx.Coins)       // add the context transaction coins
-			state.SetAccount(store, ctx.CallerAddress, acc) // save the new balance
-		}
-	}()
+	//All writes of the tx are buffered and only committed on success,
+	// so an error anywhere leaves the store untouched
+	txStore := newTxStore(store)
+	res = p2v.runTx(txStore, ctx, txBytes)
+	if res.IsErr() {
+		//Return the ctx coins to the wallet, which is the only write on error
+		payAccount(store, ctx.CallerAddress, ctx.Coins)
+		return res
+	}
+	txStore.commit()
+	return res
+}
+
+func (p2v *P2VPlugin) runTx(store types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
 
 	//Determine the transaction type and then send to the appropriate transaction function
 	if len(txBytes) < 1 {
@@ -139,21 +267,37 @@ func (p2v *P2VPlugin) RunTx(store types.KVStore, ctx types.CallContext, txBytes
 		return p2v.runTxCreateIssue(store, ctx, txBytes[1:])
 	case TypeByteTxVote:
 		return p2v.runTxVote(store, ctx, txBytes[1:])
+	case TypeByteTxDelegate:
+		return p2v.runTxDelegate(store, ctx, txBytes[1:])
+	case TypeByteTxCancel:
+		return p2v.runTxCancelIssue(store, ctx, txBytes[1:])
+	case TypeByteTxStake:
+		return p2v.runTxStake(store, ctx, txBytes[1:], false)
+	case TypeByteTxUnstake:
+		return p2v.runTxStake(store, ctx, txBytes[1:], true)
 	default:
 		return abci.ErrBaseEncodingError.AppendLog("Error decoding tx: bad prepended bytes")
 	}
 }
 
+//payAccount adds coins to the balance of an account, the account is always
+// loaded from the store as ctx.CallerAccount may be outdated
+func payAccount(store types.KVStore, addr []byte, coins types.Coins) {
+	if coins.IsZero() {
+		return
+	}
+	acc := state.GetAccount(store, addr)
+	if acc == nil {
+		acc = &types.Account{}
+	}
+	acc.Balance = acc.Balance.Plus(coins)
+	state.SetAccount(store, addr, acc) // save the new balance
+}
+
 func chargeFee(store types.KVStore, ctx types.CallContext, fee types.Coins) {
 
-	//Charge the Fee from the context coins
-	leftoverCoins := ctx.Coins.Minus(fee)
-	if !leftoverCoins.IsZero() {
-		acc := ctx.CallerAccount
-		//return leftover coins
-		acc.Balance = acc.Balance.Plus(leftoverCoins)   // subtract fees
-		state.SetAccount(store, ctx.CallerAddress, acc) // save the new balance
-	}
+	//Charge the Fee from the context coins and return leftover coins
+	payAccount(store, ctx.CallerAddress, ctx.Coins.Minus(fee))
 }
 
 func (p2v *P2VPlugin) runTxCreateIssue(store types.KVStore, ctx types.CallContext, txBytes []byte) (res abci.Result) {
@@ -169,6 +313,14 @@ func (p2v *P2VPlugin) runTxCreateIssue(store types.KVStore, ctx types.CallContex
 	switch {
 	case len(tx.Issue) == 0:
 		return abci.ErrInternalError.AppendLog("P2VTx.Issue must have a length greater than 0")
+	case len(tx.Metadata.Title) > MaxTitleLength:
+		return abci.ErrBaseInvalidInput.AppendLog(fmt.Sprintf("P2VTx.Metadata.Title must not exceed %v bytes", MaxTitleLength))
+	case len(tx.Metadata.Description) > MaxDescriptionLength:
+		return abci.ErrBaseInvalidInput.AppendLog(fmt.Sprintf("P2VTx.Metadata.Description must not exceed %v bytes", MaxDescriptionLength))
+	case len(tx.Metadata.URL) > MaxURLLength:
+		return abci.ErrBaseInvalidInput.AppendLog(fmt.Sprintf("P2VTx.Metadata.URL must not exceed %v bytes", MaxURLLength))
+	case len(tx.Metadata.ContentHash) > MaxContentHashLength:
+		return abci.ErrBaseInvalidInput.AppendLog(fmt.Sprintf("P2VTx.Metadata.ContentHash must not exceed %v bytes", MaxContentHashLength))
 	case !tx.FeePerVote.IsValid():
 		return abci.ErrInternalError.AppendLog("P2VTx.Fee2Vote is not sorted or has zero amounts")
 	case !tx.FeePerVote.IsNonnegative():
@@ -181,13 +333,29 @@ func (p2v *P2VPlugin) runTxCreateIssue(store types.KVStore, ctx types.CallContex
 		return abci.ErrInsufficientFunds.AppendLog("Tx Funds insufficient for creating a new issue")
 	}
 
-	//Return if the issue already exists, aka no error was thrown
-	if _, err := getIssue(store, tx.Issue); err == nil {
+	//Stake is weighed in the denom of the vote fee, so it must be exactly one
+	cfg := loadConfig(store)
+	if cfg.DelegationMode == DelegationStakeWeighted && len(tx.FeePerVote) != 1 {
+		return abci.ErrBaseInvalidInput.AppendLog("P2VTx.Fee2Vote must be a single coin with stake weighted delegation")
+	}
+
+	//Return if the issue already exists, aka no error was thrown,
+	// a cancelled issue keeps its name so its vote receipts stay unambiguous
+	if p2vIssue, err := getIssue(store, tx.Issue); err == nil {
+		if p2vIssue.Cancelled {
+			return abci.ErrInternalError.AppendLog("Cannot create an issue with the name of a cancelled issue")
+		}
 		return abci.ErrInternalError.AppendLog("Cannot create an already existing issue")
 	}
 
 	// Create and Save P2VIssue, charge fee, return
-	newP2VIssue := newP2VIssue(tx.Issue, tx.FeePerVote)
+	//  the cancel policy is fixed at creation
+	newP2VIssue := newP2VIssue(tx.Issue, tx.Metadata, tx.FeePerVote)
+	newP2VIssue.Creator = ctx.CallerAddress
+	newP2VIssue.EscrowFees = cfg.EscrowFees
+	if cfg.CancelWindow > 0 {
+		newP2VIssue.CancelDeadline = p2v.height + cfg.CancelWindow
+	}
 	store.Set(IssueKey(tx.Issue), wire.BinaryBytes(newP2VIssue))
 	chargeFee(store, ctx, tx.Fee2CreateIssue)
 	return abci.OK
@@ -212,6 +380,9 @@ func (p2v *P2VPlugin) runTxVote(store types.KVStore, ctx types.CallContext, txBy
 	if err != nil {
 		return abci.ErrInternalError.AppendLog("error loading issue: " + err.Error())
 	}
+	if p2vIssue.Cancelled {
+		return abci.ErrUnauthorized.AppendLog("Cannot vote on a cancelled issue")
+	}
 
 	// Did the caller provide enough coins?
 	if !ctx.Coins.IsGTE(p2vIssue.FeePerVote) {
@@ -228,6 +399,29 @@ func (p2v *P2VPlugin) runTxVote(store types.KVStore, ctx types.CallContext, txBy
 		return abci.ErrInternalError.AppendLog("P2VTx.VoteTypeByte was not recognized")
 	}
 
+	//A direct vote overrides any delegate voting on the callers behalf,
+	// and a delegate carries the votes of those who delegated to them
+	cfg := loadConfig(store)
+	markVotedDirectly(store, &p2vIssue, ctx.CallerAddress, tx.VoteTypeByte, cfg)
+	countDelegators(store, &p2vIssue, ctx.CallerAddress, cfg)
+
+	// Keep a receipt of the vote for audits
+	err = recordVote(store, P2VVoteRecord{
+		Voter:        ctx.CallerAddress,
+		Issue:        tx.Issue,
+		VoteTypeByte: tx.VoteTypeByte,
+		FeePaid:      p2vIssue.FeePerVote,
+		Height:       p2v.height,
+	})
+	if err != nil {
+		return abci.ErrInternalError.AppendLog("error recording vote: " + err.Error())
+	}
+
+	// Hold on to the fee if it may be refunded
+	if p2vIssue.EscrowFees {
+		p2vIssue.EscrowedFees = p2vIssue.EscrowedFees.Plus(p2vIssue.FeePerVote)
+	}
+
 	// Save P2VIssue, charge fee, return
 	store.Set(IssueKey(tx.Issue), wire.BinaryBytes(p2vIssue))
 	chargeFee(store, ctx, p2vIssue.FeePerVote)
@@ -237,9 +431,12 @@ func (p2v *P2VPlugin) runTxVote(store types.KVStore, ctx types.CallContext, txBy
 func (p2v *P2VPlugin) InitChain(store types.KVStore, vals []*abci.Validator) {
 }
 
+//track the height for the vote records
 func (p2v *P2VPlugin) BeginBlock(store types.KVStore, hash []byte, header *abci.Header) {
+	p2v.height = header.Height
 }
 
 func (p2v *P2VPlugin) EndBlock(store types.KVStore, height uint64) (res abci.ResponseEndBlock) {
+	p2v.height = height + 1
 	return
 }
@@ -171,3 +171,498 @@ func TestP2VPlugin(t *testing.T) {
 	testBalance(startBal.Minus(types.Coins{{"issueToken", 1}, {"voteToken", 4}}))
 	testIssue(issue1, 1, 1)
 }
+
+func TestP2VDelegation(t *testing.T) {
+	assert := assert.New(t)
+
+	store := types.NewMemKVStore()
+	P2VPlugin := New()
+	alice, bob, carol, dave := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	for _, addr := range [][]byte{alice, bob, carol, dave} {
+		state.SetAccount(store, addr, &types.Account{Balance: types.Coins{{"voteToken", 10}}})
+	}
+
+	runTx := func(caller []byte, txBytes []byte) abci.Result {
+		ctx := types.NewCallContext(caller, state.GetAccount(store, caller), nil)
+		return P2VPlugin.RunTx(store, ctx, txBytes)
+	}
+
+	testTally := func(issue string, expFor, expAgainst int, expDelFor, expDelAgainst int64) {
+		p2vIssue, err := getIssue(store, issue)
+		if assert.Nil(err) {
+			assert.Equal(expFor, p2vIssue.VotesFor, "votes-for")
+			assert.Equal(expAgainst, p2vIssue.VotesAgainst, "votes-against")
+			assert.Equal(expDelFor, p2vIssue.DelegatedFor, "delegated-for")
+			assert.Equal(expDelAgainst, p2vIssue.DelegatedAgainst, "delegated-against")
+		}
+	}
+
+	issue1 := "free internet"
+	issue2 := "commutate foobar"
+
+	res := runTx(alice, NewCreateIssueTxBytes(issue1, nil, nil))
+	assert.True(res.IsOK(), res.String())
+
+	// Test invalid delegations
+	res = runTx(bob, NewDelegateTxBytes(bob, ""))
+	assert.True(res.IsErr(), res.String())
+	res = runTx(bob, NewDelegateTxBytes([]byte("short"), ""))
+	assert.True(res.IsErr(), res.String())
+	res = runTx(bob, NewDelegateTxBytes(alice, issue2))
+	assert.True(res.IsErr(), res.String())
+
+	// bob delegates for all issues, carol only for issue1
+	res = runTx(bob, NewDelegateTxBytes(alice, ""))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(carol, NewDelegateTxBytes(alice, issue1))
+	assert.True(res.IsOK(), res.String())
+
+	// the delegate votes for both delegators
+	res = runTx(alice, NewVoteTxBytes(issue1, TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	testTally(issue1, 1, 0, 2, 0)
+
+	// a direct vote overrides the delegate
+	res = runTx(carol, NewVoteTxBytes(issue1, TypeByteVoteAgainst))
+	assert.True(res.IsOK(), res.String())
+	testTally(issue1, 1, 1, 1, 0)
+
+	// voting again does not count delegators twice
+	res = runTx(alice, NewVoteTxBytes(issue1, TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	testTally(issue1, 2, 1, 1, 0)
+
+	// but changing the vote moves the delegated weight
+	res = runTx(alice, NewVoteTxBytes(issue1, TypeByteVoteAgainst))
+	assert.True(res.IsOK(), res.String())
+	testTally(issue1, 2, 2, 0, 1)
+
+	// moving the delegation for the issue withdraws the weight,
+	// and the new delegate who already voted counts it right away
+	res = runTx(bob, NewDelegateTxBytes(carol, issue1))
+	assert.True(res.IsOK(), res.String())
+	testTally(issue1, 2, 2, 0, 1)
+	res = runTx(carol, NewVoteTxBytes(issue1, TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	testTally(issue1, 3, 2, 1, 0)
+
+	// a delegation for all issues made after the delegate voted is counted,
+	// moving or revoking it moves or withdraws the weight
+	res = runTx(dave, NewDelegateTxBytes(alice, ""))
+	assert.True(res.IsOK(), res.String())
+	testTally(issue1, 3, 2, 1, 1)
+	res = runTx(dave, NewDelegateTxBytes(carol, ""))
+	assert.True(res.IsOK(), res.String())
+	testTally(issue1, 3, 2, 2, 0)
+	res = runTx(dave, NewDelegateTxBytes(nil, ""))
+	assert.True(res.IsOK(), res.String())
+	testTally(issue1, 3, 2, 1, 0)
+
+	// Test stake weighted delegation
+	res = runTx(alice, NewStakeTxBytes(types.Coins{{"voteToken", 1}}))
+	assert.True(res.IsErr(), res.String()) // stake is not used
+	assert.NotEqual("", P2VPlugin.SetOption(store, OptionDelegationMode, "foobar"))
+	assert.Equal(DelegationOneAddress, loadConfig(store).DelegationMode)
+	P2VPlugin.SetOption(store, OptionDelegationMode, DelegationStakeWeighted)
+	assert.Equal(DelegationStakeWeighted, loadConfig(store).DelegationMode)
+
+	// the coins sent with a tx are taken from the account, like the app does
+	runTxCoins := func(caller []byte, coins types.Coins, txBytes []byte) abci.Result {
+		acc := state.GetAccount(store, caller)
+		acc.Balance = acc.Balance.Minus(coins)
+		state.SetAccount(store, caller, acc)
+		ctx := types.NewCallContext(caller, acc, coins)
+		return P2VPlugin.RunTx(store, ctx, txBytes)
+	}
+	testStake := func(issue string, expFor, expAgainst, expDelFor, expDelAgainst int64) {
+		p2vIssue, err := getIssue(store, issue)
+		if assert.Nil(err) {
+			assert.Equal(expFor, p2vIssue.StakeFor, "stake-for")
+			assert.Equal(expAgainst, p2vIssue.StakeAgainst, "stake-against")
+			assert.Equal(expDelFor, p2vIssue.DelegatedFor, "delegated-for")
+			assert.Equal(expDelAgainst, p2vIssue.DelegatedAgainst, "delegated-against")
+		}
+	}
+	testBalance := func(addr []byte, expected types.Coins) {
+		acc := state.GetAccount(store, addr)
+		assert.True(expected.IsEqual(acc.Balance), "expected %v, got %v", expected, acc.Balance)
+	}
+	voteFee := types.Coins{{"voteToken", 1}}
+
+	// votes are weighed in the single denom of the vote fee
+	res = runTx(alice, NewCreateIssueTxBytes(issue2, nil, nil))
+	assert.True(res.IsErr(), res.String())
+	res = runTx(alice, NewCreateIssueTxBytes(issue2, types.Coins{{"otherToken", 1}, {"voteToken", 1}}, nil))
+	assert.True(res.IsErr(), res.String())
+	res = runTx(alice, NewCreateIssueTxBytes(issue2, voteFee, nil))
+	assert.True(res.IsOK(), res.String())
+
+	res = runTx(alice, NewStakeTxBytes(types.Coins{{"voteToken", 4}}))
+	assert.True(res.IsErr(), res.String()) // no coins sent
+	res = runTxCoins(alice, types.Coins{{"voteToken", 4}}, NewStakeTxBytes(types.Coins{{"voteToken", 4}}))
+	assert.True(res.IsOK(), res.String())
+	res = runTxCoins(bob, types.Coins{{"voteToken", 8}}, NewStakeTxBytes(types.Coins{{"voteToken", 8}}))
+	assert.True(res.IsOK(), res.String())
+	res = runTxCoins(carol, types.Coins{{"voteToken", 6}}, NewStakeTxBytes(types.Coins{{"voteToken", 6}}))
+	assert.True(res.IsOK(), res.String())
+	testBalance(bob, types.Coins{{"voteToken", 2}})
+
+	// the direct voter and the delegator of all issues count their stake,
+	// voting again does not count the stake twice
+	res = runTxCoins(alice, voteFee, NewVoteTxBytes(issue2, TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	testStake(issue2, 4, 0, 8, 0)
+	res = runTxCoins(alice, voteFee, NewVoteTxBytes(issue2, TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	testStake(issue2, 4, 0, 8, 0)
+	testTally(issue2, 2, 0, 8, 0)
+
+	// delegating after the delegate voted follows that vote
+	res = runTx(carol, NewDelegateTxBytes(alice, issue2))
+	assert.True(res.IsOK(), res.String())
+	testStake(issue2, 4, 0, 14, 0)
+	res = runTx(carol, NewDelegateTxBytes(nil, issue2))
+	assert.True(res.IsOK(), res.String())
+	testStake(issue2, 4, 0, 8, 0)
+
+	// a change of stake applies to the issues already counted
+	res = runTxCoins(alice, voteFee, NewStakeTxBytes(voteFee))
+	assert.True(res.IsOK(), res.String())
+	testStake(issue2, 5, 0, 8, 0)
+
+	// coins can only be sent away once unstaked, which withdraws their
+	// weight, so the receiver does not count them a second time
+	res = runTx(bob, NewUnstakeTxBytes(types.Coins{{"voteToken", 9}}))
+	assert.True(res.IsErr(), res.String()) // more than staked
+	res = runTx(bob, NewUnstakeTxBytes(types.Coins{{"voteToken", 8}}))
+	assert.True(res.IsOK(), res.String())
+	testBalance(bob, types.Coins{{"voteToken", 10}})
+	testStake(issue2, 5, 0, 0, 0)
+	assert.Empty(getStake(store, bob))
+
+	state.SetAccount(store, bob, &types.Account{})
+	state.SetAccount(store, carol, &types.Account{Balance: types.Coins{{"voteToken", 14}}})
+	res = runTxCoins(carol, types.Coins{{"voteToken", 8}}, NewStakeTxBytes(types.Coins{{"voteToken", 8}}))
+	assert.True(res.IsOK(), res.String())
+	res = runTxCoins(carol, voteFee, NewVoteTxBytes(issue2, TypeByteVoteAgainst))
+	assert.True(res.IsOK(), res.String())
+	testStake(issue2, 5, 14, 0, 0)
+
+	// revoking the delegation for all issues after the delegate voted
+	res = runTxCoins(dave, types.Coins{{"voteToken", 3}}, NewStakeTxBytes(types.Coins{{"voteToken", 3}}))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(dave, NewDelegateTxBytes(alice, ""))
+	assert.True(res.IsOK(), res.String())
+	testStake(issue2, 5, 14, 3, 0)
+	res = runTx(dave, NewDelegateTxBytes(nil, ""))
+	assert.True(res.IsOK(), res.String())
+	testStake(issue2, 5, 14, 0, 0)
+}
+
+func TestP2VIssueMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	store := types.NewMemKVStore()
+	P2VPlugin := New()
+	creator := cmn.RandBytes(20)
+	state.SetAccount(store, creator, &types.Account{})
+
+	runTx := func(txBytes []byte) abci.Result {
+		ctx := types.NewCallContext(creator, state.GetAccount(store, creator), nil)
+		return P2VPlugin.RunTx(store, ctx, txBytes)
+	}
+
+	metadata := P2VMetadata{
+		Title:       "Free internet for everyone",
+		Description: "All nodes should provide free bandwidth to their neighbours",
+		URL:         "https://example.com/free-internet.pdf",
+		ContentHash: cmn.RandBytes(32),
+	}
+
+	// Test the metadata is stored with the issue
+	res := runTx(NewCreateIssueWithMetadataTxBytes("free internet", metadata, nil, nil))
+	assert.True(res.IsOK(), res.String())
+	p2vIssue, err := getIssue(store, "free internet")
+	if assert.Nil(err) {
+		assert.Equal(metadata, p2vIssue.Metadata)
+	}
+
+	// Test the size limits are enforced
+	tooLong := []P2VMetadata{
+		{Title: string(cmn.RandBytes(MaxTitleLength + 1))},
+		{Description: string(cmn.RandBytes(MaxDescriptionLength + 1))},
+		{URL: string(cmn.RandBytes(MaxURLLength + 1))},
+		{ContentHash: cmn.RandBytes(MaxContentHashLength + 1)},
+	}
+	for i, metadata := range tooLong {
+		issue := cmn.Fmt("issue %v", i)
+		res = runTx(NewCreateIssueWithMetadataTxBytes(issue, metadata, nil, nil))
+		assert.True(res.IsErr(), res.String())
+		_, err = getIssue(store, issue)
+		assert.NotNil(err)
+	}
+}
+
+func TestP2VVoteRecords(t *testing.T) {
+	assert := assert.New(t)
+
+	store := types.NewMemKVStore()
+	P2VPlugin := New()
+	alice, bob := cmn.RandBytes(20), cmn.RandBytes(20)
+	fee := types.Coins{{"voteToken", 2}}
+
+	runTx := func(caller []byte, coins types.Coins, txBytes []byte) abci.Result {
+		ctx := types.NewCallContext(caller, &types.Account{}, coins)
+		return P2VPlugin.RunTx(store, ctx, txBytes)
+	}
+
+	issue := "free internet"
+	res := runTx(alice, nil, NewCreateIssueTxBytes(issue, fee, nil))
+	assert.True(res.IsOK(), res.String())
+
+	P2VPlugin.BeginBlock(store, nil, &abci.Header{Height: 10})
+	res = runTx(alice, fee, NewVoteTxBytes(issue, TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	P2VPlugin.EndBlock(store, 10)
+	res = runTx(bob, fee, NewVoteTxBytes(issue, TypeByteVoteAgainst))
+	assert.True(res.IsOK(), res.String())
+
+	// failed votes leave no receipt
+	res = runTx(bob, nil, NewVoteTxBytes(issue, TypeByteVoteFor))
+	assert.True(res.IsErr(), res.String())
+
+	aliceVote := P2VVoteRecord{alice, issue, TypeByteVoteFor, fee, 10}
+	bobVote := P2VVoteRecord{bob, issue, TypeByteVoteAgainst, fee, 11}
+
+	votes, err := getVotes(store, VoterVotesKey(alice))
+	assert.Nil(err)
+	assert.Equal([]P2VVoteRecord{aliceVote}, votes)
+	votes, err = getVotes(store, VoterVotesKey(bob))
+	assert.Nil(err)
+	assert.Equal([]P2VVoteRecord{bobVote}, votes)
+	votes, err = getVotes(store, IssueVotesKey(issue))
+	assert.Nil(err)
+	assert.Equal([]P2VVoteRecord{aliceVote, bobVote}, votes)
+
+	// each receipt has its own key next to a counter
+	count, err := getVoteCount(store, IssueVotesKey(issue))
+	assert.Nil(err)
+	assert.Equal(uint64(2), count)
+	vote, err := GetVoteFromWire(store.Get(VoteRecordKey(IssueVotesKey(issue), 1)))
+	assert.Nil(err)
+	assert.Equal(bobVote, vote)
+
+	// no votes is not an error
+	votes, err = getVotes(store, IssueVotesKey("commutate foobar"))
+	assert.Nil(err)
+	assert.Empty(votes)
+}
+
+func TestP2VBalanceConservation(t *testing.T) {
+	assert := assert.New(t)
+
+	store := types.NewMemKVStore()
+	P2VPlugin := New()
+	caller, delegate := cmn.RandBytes(20), cmn.RandBytes(20)
+	startBal := types.Coins{{"issueToken", 1000}, {"voteToken", 1000}}
+	state.SetAccount(store, caller, &types.Account{Balance: startBal})
+
+	// deliverTx deducts the coins from the caller like basecoin does,
+	// and passes an outdated account in the context which must not be used
+	deliverTx := func(coins types.Coins, txBytes []byte) abci.Result {
+		acc := state.GetAccount(store, caller)
+		acc.Balance = acc.Balance.Minus(coins)
+		state.SetAccount(store, caller, acc)
+		ctx := types.NewCallContext(caller, &types.Account{}, coins)
+		return P2VPlugin.RunTx(store, ctx, txBytes)
+	}
+
+	testBalance := func(expected types.Coins) {
+		acc := state.GetAccount(store, caller)
+		if assert.NotNil(acc) {
+			assert.True(expected.IsEqual(acc.Balance), "expected %v, got %v", expected, acc.Balance)
+		}
+	}
+
+	issue := "free internet"
+	coins := types.Coins{{"issueToken", 2}, {"voteToken", 2}}
+	voteFee := types.Coins{{"voteToken", 1}}
+	createFee := types.Coins{{"issueToken", 1}}
+
+	// Test successful txs only charge the fee
+	res := deliverTx(coins, NewCreateIssueTxBytes(issue, voteFee, createFee))
+	assert.True(res.IsOK(), res.String())
+	bal := startBal.Minus(createFee)
+	testBalance(bal)
+	res = deliverTx(coins, NewVoteTxBytes(issue, TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	bal = bal.Minus(voteFee)
+	testBalance(bal)
+
+	// Test all error paths return exactly the tx coins
+	errTxs := [][]byte{
+		{},
+		{0xFF},
+		{TypeByteTxCreate, 0x01},
+		NewCreateIssueTxBytes("", voteFee, createFee),
+		NewCreateIssueTxBytes(issue, voteFee, createFee),
+		NewCreateIssueTxBytes("commutate foobar", voteFee, types.Coins{{"issueToken", 3}}),
+		NewCreateIssueWithMetadataTxBytes("commutate foobar",
+			P2VMetadata{Title: string(cmn.RandBytes(MaxTitleLength + 1))}, voteFee, createFee),
+		NewVoteTxBytes("commutate foobar", TypeByteVoteFor),
+		NewVoteTxBytes(issue, 0x05),
+		NewDelegateTxBytes(caller, issue),
+		NewDelegateTxBytes(delegate, "commutate foobar"),
+	}
+	for i, txBytes := range errTxs {
+		res = deliverTx(coins, txBytes)
+		assert.True(res.IsErr(), "%v: %v", i, res.String())
+		testBalance(bal)
+	}
+	res = deliverTx(types.Coins{{"voteToken", 3}}, NewCreateIssueTxBytes("commutate foobar", voteFee, createFee))
+	assert.True(res.IsErr(), res.String())
+	testBalance(bal)
+	p2vIssue, err := getIssue(store, issue)
+	if assert.Nil(err) {
+		assert.Equal(1, p2vIssue.VotesFor)
+		assert.Equal(0, p2vIssue.VotesAgainst)
+	}
+
+	// Test a failure after the first writes rolls back completely
+	issue2 := "commutate foobar"
+	res = deliverTx(coins, NewCreateIssueTxBytes(issue2, voteFee, createFee))
+	assert.True(res.IsOK(), res.String())
+	bal = bal.Minus(createFee)
+	store.Set(IssueVotesKey(issue2), []byte{0xFF}) // corrupt receipts fail the vote
+	res = deliverTx(coins, NewVoteTxBytes(issue2, TypeByteVoteAgainst))
+	assert.True(res.IsErr(), res.String())
+	testBalance(bal)
+	assert.False(hasVotedDirectly(store, issue2, caller))
+	votes, err := getVotes(store, VoterVotesKey(caller))
+	assert.Nil(err)
+	assert.Equal(1, len(votes))
+	p2vIssue, err = getIssue(store, issue2)
+	if assert.Nil(err) {
+		assert.Equal(0, p2vIssue.VotesFor)
+		assert.Equal(0, p2vIssue.VotesAgainst)
+	}
+}
+
+func TestP2VCancelIssue(t *testing.T) {
+	assert := assert.New(t)
+
+	store := types.NewMemKVStore()
+	P2VPlugin := New()
+	creator, voter := cmn.RandBytes(20), cmn.RandBytes(20)
+	voteFee := types.Coins{{"voteToken", 2}}
+
+	runTx := func(caller []byte, coins types.Coins, txBytes []byte) abci.Result {
+		ctx := types.NewCallContext(caller, &types.Account{}, coins)
+		return P2VPlugin.RunTx(store, ctx, txBytes)
+	}
+
+	testVoterBalance := func(expected types.Coins) {
+		acc := state.GetAccount(store, voter)
+		if assert.NotNil(acc) {
+			assert.True(expected.IsEqual(acc.Balance), "expected %v, got %v", expected, acc.Balance)
+		}
+	}
+
+	issue1 := "free internet"
+	issue2 := "commutate foobar"
+	issue3 := "open borders"
+	issue4 := "universal income"
+
+	// Test default policy, only without votes
+	res := runTx(creator, nil, NewCreateIssueTxBytes(issue1, voteFee, nil))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(voter, nil, NewCancelIssueTxBytes(issue1))
+	assert.True(res.IsErr(), res.String()) // not the creator
+	res = runTx(creator, nil, NewCancelIssueTxBytes(issue1))
+	assert.True(res.IsOK(), res.String())
+	p2vIssue, err := getIssue(store, issue1)
+	if assert.Nil(err) {
+		assert.True(p2vIssue.Cancelled)
+	}
+	res = runTx(creator, nil, NewCancelIssueTxBytes(issue1))
+	assert.True(res.IsErr(), res.String()) // already cancelled
+	res = runTx(voter, voteFee, NewVoteTxBytes(issue1, TypeByteVoteFor))
+	assert.True(res.IsErr(), res.String())
+	testVoterBalance(voteFee) // the fee is returned
+	res = runTx(creator, nil, NewCreateIssueTxBytes(issue1, voteFee, nil))
+	assert.True(res.IsErr(), res.String()) // the name stays taken
+
+	res = runTx(creator, nil, NewCreateIssueTxBytes(issue4, voteFee, nil))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(voter, voteFee, NewVoteTxBytes(issue4, TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(creator, nil, NewCancelIssueTxBytes(issue4))
+	assert.True(res.IsErr(), res.String())
+
+	// Test deadline policy with escrowed fees
+	P2VPlugin.SetOption(store, OptionCancelWindow, "10")
+	P2VPlugin.SetOption(store, OptionEscrowFees, "true")
+	P2VPlugin.BeginBlock(store, nil, &abci.Header{Height: 100})
+	res = runTx(creator, nil, NewCreateIssueTxBytes(issue2, voteFee, nil))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(voter, voteFee, NewVoteTxBytes(issue2, TypeByteVoteFor))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(voter, voteFee, NewVoteTxBytes(issue2, TypeByteVoteAgainst))
+	assert.True(res.IsOK(), res.String())
+	p2vIssue, err = getIssue(store, issue2)
+	if assert.Nil(err) {
+		assert.Equal(uint64(110), p2vIssue.CancelDeadline)
+		assert.Equal(voteFee.Plus(voteFee), p2vIssue.EscrowedFees)
+	}
+
+	// the refunds must be covered by the escrowed fees
+	issueBytes := store.Get(IssueKey(issue2))
+	short := p2vIssue
+	short.EscrowedFees = voteFee
+	store.Set(IssueKey(issue2), wire.BinaryBytes(short))
+	P2VPlugin.BeginBlock(store, nil, &abci.Header{Height: 110})
+	res = runTx(creator, nil, NewCancelIssueTxBytes(issue2))
+	assert.True(res.IsErr(), res.String())
+	testVoterBalance(voteFee)
+	store.Set(IssueKey(issue2), issueBytes)
+
+	// the voter gets back both fees, the history of the issue stays
+	res = runTx(creator, nil, NewCancelIssueTxBytes(issue2))
+	assert.True(res.IsOK(), res.String())
+	testVoterBalance(voteFee.Plus(voteFee).Plus(voteFee))
+	p2vIssue, err = getIssue(store, issue2)
+	if assert.Nil(err) {
+		assert.True(p2vIssue.Cancelled)
+		assert.Empty(p2vIssue.EscrowedFees)
+		assert.Equal(1, p2vIssue.VotesFor)
+		assert.Equal(1, p2vIssue.VotesAgainst)
+	}
+	votes, err := getVotes(store, IssueVotesKey(issue2))
+	assert.Nil(err)
+	assert.Equal(2, len(votes))
+	votes, err = getVotes(store, VoterVotesKey(voter))
+	assert.Nil(err)
+	assert.Equal(3, len(votes))
+
+	// the deadline is counted from the creation
+	P2VPlugin.BeginBlock(store, nil, &abci.Header{Height: 111})
+	res = runTx(creator, nil, NewCreateIssueTxBytes(issue3, voteFee, nil))
+	assert.True(res.IsOK(), res.String())
+	P2VPlugin.BeginBlock(store, nil, &abci.Header{Height: 122})
+	res = runTx(creator, nil, NewCancelIssueTxBytes(issue3))
+	assert.True(res.IsErr(), res.String()) // past the deadline
+
+	// delegations for a cancelled issue are cleared and no new ones made
+	issue5 := "free education"
+	res = runTx(creator, nil, NewCreateIssueTxBytes(issue5, voteFee, nil))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(voter, nil, NewDelegateTxBytes(creator, issue5))
+	assert.True(res.IsOK(), res.String())
+	res = runTx(creator, nil, NewCancelIssueTxBytes(issue5))
+	assert.True(res.IsOK(), res.String())
+	assert.Empty(getDelegate(store, voter, issue5))
+	assert.Empty(getDelegators(store, creator, issue5))
+	assert.Empty(getIssueDelegators(store, issue5))
+	res = runTx(voter, nil, NewDelegateTxBytes(creator, issue5))
+	assert.True(res.IsErr(), res.String())
+}
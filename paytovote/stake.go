@@ -0,0 +1,82 @@
+package paytovote
+
+import (
+	"fmt"
+
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/basecoin/types"
+	"github.com/tendermint/go-wire"
+)
+
+//StakeKey stores the coins an address locked with the plugin as voting
+// stake, locked coins cannot be sent away while they are counted
+func StakeKey(addr []byte) []byte {
+	return []byte(fmt.Sprintf("P2VPlugin,stake=%X", addr))
+}
+
+//get the stake from store bytes
+func GetStakeFromWire(stakeBytes []byte) (stake types.Coins, err error) {
+	if len(stakeBytes) > 0 {
+		err = wire.ReadBinaryBytes(stakeBytes, &stake)
+		if err != nil {
+			err = abci.ErrInternalError.AppendLog("Error decoding stake: " + err.Error())
+		}
+	}
+	return
+}
+
+func getStake(store types.KVStore, addr []byte) types.Coins {
+	stake, err := GetStakeFromWire(store.Get(StakeKey(addr)))
+	if err != nil {
+		return nil
+	}
+	return stake
+}
+
+func setStake(store types.KVStore, addr []byte, stake types.Coins) {
+	if stake.IsZero() {
+		store.Set(StakeKey(addr), nil)
+		return
+	}
+	store.Set(StakeKey(addr), wire.BinaryBytes(stake))
+}
+
+func (p2v *P2VPlugin) runTxStake(store types.KVStore, ctx types.CallContext, txBytes []byte, unstake bool) (res abci.Result) {
+
+	// Decode tx
+	var tx stakeTx
+	err := wire.ReadBinaryBytes(txBytes, &tx)
+	if err != nil {
+		return abci.ErrBaseEncodingError.AppendLog("Error decoding tx: " + err.Error())
+	}
+
+	//Validate Tx
+	cfg := loadConfig(store)
+	stake := getStake(store, ctx.CallerAddress)
+	switch {
+	case cfg.DelegationMode != DelegationStakeWeighted:
+		return abci.ErrUnauthorized.AppendLog("Stake is only counted in the stake weighted delegation mode")
+	case tx.Coins.IsZero() || !tx.Coins.IsValid() || !tx.Coins.IsPositive():
+		return abci.ErrBaseInvalidInput.AppendLog("P2VTx.Coins must be sorted and positive")
+	case !unstake && !ctx.Coins.IsGTE(tx.Coins):
+		return abci.ErrInsufficientFunds.AppendLog("Tx Funds insufficient for the stake")
+	case unstake && !stake.IsGTE(tx.Coins):
+		return abci.ErrInsufficientFunds.AppendLog("Stake insufficient for the withdrawal")
+	}
+
+	// Lock or unlock the coins, return the rest
+	if unstake {
+		setStake(store, ctx.CallerAddress, stake.Minus(tx.Coins))
+		payAccount(store, ctx.CallerAddress, tx.Coins)
+		chargeFee(store, ctx, nil)
+	} else {
+		setStake(store, ctx.CallerAddress, stake.Plus(tx.Coins))
+		chargeFee(store, ctx, tx.Coins)
+	}
+
+	//The new stake applies to every issue the caller is counted on
+	forCountedIssues(store, [][]byte{ctx.CallerAddress}, func(p2vIssue *P2VIssue) {
+		reweigh(store, p2vIssue, ctx.CallerAddress, cfg)
+	})
+	return abci.OK
+}
@@ -0,0 +1,42 @@
+package paytovote
+
+import "github.com/tendermint/basecoin/types"
+
+//txStore buffers all writes of a transaction on top of the store, they
+// only reach the underlying store on commit, so a failed transaction
+// can be dropped without leaving any partial writes behind
+type txStore struct {
+	store  types.KVStore
+	writes map[string][]byte
+	keys   []string //order of the first write to each key, for a deterministic commit
+}
+
+func newTxStore(store types.KVStore) *txStore {
+	return &txStore{
+		store:  store,
+		writes: make(map[string][]byte),
+	}
+}
+
+func (t *txStore) Get(key []byte) []byte {
+	if value, ok := t.writes[string(key)]; ok {
+		return value
+	}
+	return t.store.Get(key)
+}
+
+func (t *txStore) Set(key, value []byte) {
+	if _, ok := t.writes[string(key)]; !ok {
+		t.keys = append(t.keys, string(key))
+	}
+	t.writes[string(key)] = value
+}
+
+//commit writes all buffered values to the underlying store
+func (t *txStore) commit() {
+	for _, key := range t.keys {
+		t.store.Set([]byte(key), t.writes[key])
+	}
+	t.writes = make(map[string][]byte)
+	t.keys = nil
+}
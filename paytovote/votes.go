@@ -0,0 +1,101 @@
+package paytovote
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	abci "github.com/tendermint/abci/types"
+	"github.com/tendermint/basecoin/types"
+	"github.com/tendermint/go-wire"
+)
+
+//P2VVoteRecord is the receipt of a successful vote, stored both
+// under the voter and under the issue to allow audits of the tallies
+type P2VVoteRecord struct {
+	Voter        []byte
+	Issue        string
+	VoteTypeByte byte
+	FeePaid      types.Coins
+	Height       uint64
+}
+
+//VoterVotesKey and IssueVotesKey store how many receipts a voter or an
+// issue has, each receipt is stored under its own VoteRecordKey so
+// recording a vote costs the same however many came before it
+func VoterVotesKey(voter []byte) []byte {
+	return []byte(fmt.Sprintf("P2VPlugin,votes,voter=%X", voter))
+}
+
+func IssueVotesKey(issue string) []byte {
+	return []byte(fmt.Sprintf("P2VPlugin,votes,issue=%v", issue))
+}
+
+//VoteRecordKey stores the receipt with the sequence number seq of a
+// voter or issue, starting at 0
+func VoteRecordKey(votesKey []byte, seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s,seq=%d", votesKey, seq))
+}
+
+//get the number of receipts from store bytes, no bytes are no votes
+func GetVoteCountFromWire(countBytes []byte) (uint64, error) {
+	switch len(countBytes) {
+	case 0:
+		return 0, nil
+	case 8:
+		return binary.BigEndian.Uint64(countBytes), nil
+	}
+	return 0, abci.ErrInternalError.AppendLog("Error decoding state: invalid vote count")
+}
+
+//get a vote record from store bytes
+func GetVoteFromWire(voteBytes []byte) (record P2VVoteRecord, err error) {
+	err = wire.ReadBinaryBytes(voteBytes, &record)
+	if err != nil {
+		err = abci.ErrInternalError.AppendLog("Error decoding state: " + err.Error())
+	}
+	return
+}
+
+func getVoteCount(store types.KVStore, votesKey []byte) (uint64, error) {
+	return GetVoteCountFromWire(store.Get(votesKey))
+}
+
+func setVoteCount(store types.KVStore, votesKey []byte, count uint64) {
+	countBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(countBytes, count)
+	store.Set(votesKey, countBytes)
+}
+
+//getVotes loads all receipts of a voter or issue, in the order cast
+func getVotes(store types.KVStore, votesKey []byte) ([]P2VVoteRecord, error) {
+	count, err := getVoteCount(store, votesKey)
+	if err != nil {
+		return nil, err
+	}
+	var records []P2VVoteRecord
+	for seq := uint64(0); seq < count; seq++ {
+		record, err := GetVoteFromWire(store.Get(VoteRecordKey(votesKey, seq)))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func appendVote(store types.KVStore, votesKey []byte, record P2VVoteRecord) error {
+	count, err := getVoteCount(store, votesKey)
+	if err != nil {
+		return err
+	}
+	store.Set(VoteRecordKey(votesKey, count), wire.BinaryBytes(record))
+	setVoteCount(store, votesKey, count+1)
+	return nil
+}
+
+func recordVote(store types.KVStore, record P2VVoteRecord) error {
+	if err := appendVote(store, VoterVotesKey(record.Voter), record); err != nil {
+		return err
+	}
+	return appendVote(store, IssueVotesKey(record.Issue), record)
+}
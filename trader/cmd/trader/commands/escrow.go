@@ -3,6 +3,7 @@ package commands
 import (
 	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -18,12 +19,30 @@ const EscrowName = "escrow"
 
 var (
 	//flags
-	EscrowNodeFlag    string
-	EscrowRecvFlag    string
-	EscrowArbiterFlag string
-	EscrowAddrFlag    string
-	EscrowExpireFlag  uint64
-	EscrowPayoutFlag  bool
+	EscrowNodeFlag      string
+	EscrowRecvFlag      string
+	EscrowArbiterFlag   string
+	EscrowArbitersFlag  string
+	EscrowThresholdFlag int
+	EscrowArbFeeFlag    string
+	EscrowArbFeeBpsFlag int
+	EscrowAddrFlag      string
+	EscrowExpireFlag    uint64
+	EscrowPayoutFlag    bool
+	EscrowReleaseFlag   string
+	EscrowToRecvFlag    string
+	EscrowToSendFlag    string
+	EscrowHashlockFlag  string
+	EscrowPreimageFlag  string
+	EscrowListSendFlag  string
+	EscrowListRecvFlag  string
+	EscrowListArbFlag   string
+	EscrowPeriodFlag    uint64
+	EscrowFallbackFlag  bool
+	EscrowEvidenceFlag  string
+	EscrowMemoFlag      string
+	EscrowStartFlag     uint64
+	EscrowEndFlag       uint64
 
 	//commands
 	CmdEscrowTx = &cobra.Command{
@@ -43,17 +62,89 @@ var (
 		RunE:  cmdEscrowResolveTx,
 	}
 
+	CmdEscrowReleaseTx = &cobra.Command{
+		Use:   "release",
+		Short: "Release part of the escrow to the recipient, keeping the rest locked",
+		RunE:  cmdEscrowReleaseTx,
+	}
+
 	CmdEscrowExpireTx = &cobra.Command{
 		Use:   "expire",
 		Short: "Call to expire the escrow if no action in a given time",
 		RunE:  cmdEscrowExpireTx,
 	}
 
+	CmdEscrowApproveTx = &cobra.Command{
+		Use:   "approve",
+		Short: "Approve paying out or returning the money as sender or recipient",
+		RunE:  cmdEscrowApproveTx,
+	}
+
+	CmdEscrowDisputeTx = &cobra.Command{
+		Use:   "dispute",
+		Short: "Raise a dispute for the arbiter as sender or recipient",
+		RunE:  cmdEscrowDisputeTx,
+	}
+
+	CmdEscrowCreateHashlockTx = &cobra.Command{
+		Use:   "create-hashlock",
+		Short: "Create an escrow the recipient can claim with the preimage of a hash",
+		RunE:  cmdEscrowCreateHashlockTx,
+	}
+
+	CmdEscrowClaimTx = &cobra.Command{
+		Use:   "claim",
+		Short: "Claim a hashlock escrow by revealing the preimage",
+		RunE:  cmdEscrowClaimTx,
+	}
+
+	CmdEscrowRefundTx = &cobra.Command{
+		Use:   "refund",
+		Short: "Return an expired hashlock escrow to the sender",
+		RunE:  cmdEscrowExpireTx,
+	}
+
+	CmdEscrowCreateStreamTx = &cobra.Command{
+		Use:   "create-stream",
+		Short: "Create an escrow paid to the recipient in installments",
+		RunE:  cmdEscrowCreateStreamTx,
+	}
+
+	CmdEscrowWithdrawTx = &cobra.Command{
+		Use:   "withdraw",
+		Short: "Withdraw everything vested so far from a stream",
+		RunE:  cmdEscrowWithdrawTx,
+	}
+
+	CmdEscrowCancelStreamTx = &cobra.Command{
+		Use:   "cancel-stream",
+		Short: "Cancel a stream, returning the unvested coins to the sender",
+		RunE:  cmdEscrowCancelStreamTx,
+	}
+
+	CmdEscrowTopUpTx = &cobra.Command{
+		Use:   "top-up",
+		Short: "Add the coins sent to a running escrow as the sender",
+		RunE:  cmdEscrowTopUpTx,
+	}
+
+	CmdEscrowAmendTx = &cobra.Command{
+		Use:   "amend",
+		Short: "Extend the expiration or propose a new arbiter for a running escrow",
+		RunE:  cmdEscrowAmendTx,
+	}
+
 	CmdEscrowQuery = &cobra.Command{
 		Use:   "query [address]",
 		Short: "Return the contents of the given escrow",
 		RunE:  cmdEscrowQuery,
 	}
+
+	CmdEscrowList = &cobra.Command{
+		Use:   "list",
+		Short: "Return all open escrows of a sender, recipient or arbiter",
+		RunE:  cmdEscrowList,
+	}
 )
 
 func init() {
@@ -62,6 +153,12 @@ func init() {
 	queryFlags := []bcmd.Flag2Register{
 		{&EscrowNodeFlag, "node", "tcp://localhost:46657", "Tendermint RPC address"},
 	}
+	listFlags := []bcmd.Flag2Register{
+		{&EscrowNodeFlag, "node", "tcp://localhost:46657", "Tendermint RPC address"},
+		{&EscrowListSendFlag, "sender", "", "List the escrows sent by this address"},
+		{&EscrowListRecvFlag, "recipient", "", "List the escrows received by this address"},
+		{&EscrowListArbFlag, "arbiter", "", "List the escrows arbitrated by this address"},
+	}
 	addrFlag := bcmd.Flag2Register{
 		&EscrowAddrFlag, "escrow", "", "The address of this escrow"}
 	expireFlags := []bcmd.Flag2Register{
@@ -70,23 +167,87 @@ func init() {
 	resolveFlags := []bcmd.Flag2Register{
 		addrFlag,
 		{&EscrowPayoutFlag, "abort-payout", false, "Set this flag if to return the money to the sender"},
+		{&EscrowToRecvFlag, "to-recipient", "", "Split the escrow, paying these coins to the recipient"},
+		{&EscrowToSendFlag, "to-sender", "", "Split the escrow, returning these coins to the sender"},
+	}
+	approveFlags := []bcmd.Flag2Register{
+		addrFlag,
+		{&EscrowPayoutFlag, "abort-payout", false, "Set this flag if to return the money to the sender"},
+	}
+	disputeFlags := []bcmd.Flag2Register{
+		addrFlag,
+		{&EscrowEvidenceFlag, "evidence", "", "Hash of the evidence for the dispute, in hex"},
+		{&EscrowMemoFlag, "memo", "", "Short description of the dispute"},
+	}
+	releaseFlags := []bcmd.Flag2Register{
+		addrFlag,
+		{&EscrowReleaseFlag, "release", "", "Amount of coins to release in format <amt><coin>,<amt2><coin2>,..."},
 	}
 	createTxFlags := []bcmd.Flag2Register{
 		{&EscrowRecvFlag, "recv", "", "Who is the intended recipient of the escrow"},
 		{&EscrowArbiterFlag, "arbiter", "", "Who is the arbiter of the escrow"},
+		{&EscrowArbitersFlag, "arbiters", "", "Comma separated list of arbiters, instead of --arbiter"},
+		{&EscrowThresholdFlag, "threshold", 0, "How many of the arbiters must agree to resolve the escrow"},
+		{&EscrowArbFeeFlag, "arbiter-fee", "", "Fixed fee for the arbiter in format <amt><coin>,<amt2><coin2>,..."},
+		{&EscrowArbFeeBpsFlag, "arbiter-fee-bps", 0, "Fee for the arbiter in basis points of the amount, instead of --arbiter-fee"},
+		{&EscrowPeriodFlag, "decision-period", uint64(0), "Blocks the arbiter has to decide a dispute, 0 to allow no disputes"},
+		{&EscrowFallbackFlag, "fallback-payout", false, "Pay the recipient if the arbiter misses the dispute deadline, else return the money"},
 		{&EscrowExpireFlag, "expire", uint64(0), "The block height when the escrow expires"},
 	}
+	createHashlockFlags := []bcmd.Flag2Register{
+		{&EscrowRecvFlag, "recv", "", "Who is the intended recipient of the escrow"},
+		{&EscrowHashlockFlag, "hashlock", "", "The sha256 hash of the secret preimage, in hex"},
+		{&EscrowExpireFlag, "expire", uint64(0), "The block height when the sender can get a refund"},
+	}
+	claimFlags := []bcmd.Flag2Register{
+		addrFlag,
+		{&EscrowPreimageFlag, "preimage", "", "The secret preimage of the hashlock, in hex"},
+	}
+	createStreamFlags := []bcmd.Flag2Register{
+		{&EscrowRecvFlag, "recv", "", "Who is the intended recipient of the stream"},
+		{&EscrowStartFlag, "start", uint64(0), "The block height when the coins start to vest"},
+		{&EscrowEndFlag, "end", uint64(0), "The block height when all coins are vested"},
+	}
+	amendFlags := []bcmd.Flag2Register{
+		addrFlag,
+		{&EscrowExpireFlag, "expire", uint64(0), "The new, later block height when the escrow expires"},
+		{&EscrowArbiterFlag, "arbiter", "", "The new arbiter of the escrow"},
+	}
 	bcmd.RegisterFlags(CmdEscrowQuery, queryFlags)
+	bcmd.RegisterFlags(CmdEscrowTopUpTx, expireFlags)
+	bcmd.RegisterFlags(CmdEscrowAmendTx, amendFlags)
+	bcmd.RegisterFlags(CmdEscrowCreateStreamTx, createStreamFlags)
+	bcmd.RegisterFlags(CmdEscrowWithdrawTx, expireFlags)
+	bcmd.RegisterFlags(CmdEscrowCancelStreamTx, expireFlags)
+	bcmd.RegisterFlags(CmdEscrowList, listFlags)
 	bcmd.RegisterFlags(CmdEscrowExpireTx, expireFlags)
+	bcmd.RegisterFlags(CmdEscrowRefundTx, expireFlags)
+	bcmd.RegisterFlags(CmdEscrowCreateHashlockTx, createHashlockFlags)
+	bcmd.RegisterFlags(CmdEscrowClaimTx, claimFlags)
 	bcmd.RegisterFlags(CmdEscrowResolveTx, resolveFlags)
+	bcmd.RegisterFlags(CmdEscrowReleaseTx, releaseFlags)
+	bcmd.RegisterFlags(CmdEscrowApproveTx, approveFlags)
+	bcmd.RegisterFlags(CmdEscrowDisputeTx, disputeFlags)
 	bcmd.RegisterFlags(CmdEscrowCreateTx, createTxFlags)
 
 	//register subcommands of EscrowTxCmd
 	CmdEscrowTx.AddCommand(
 		CmdEscrowCreateTx,
 		CmdEscrowResolveTx,
+		CmdEscrowReleaseTx,
+		CmdEscrowApproveTx,
+		CmdEscrowDisputeTx,
 		CmdEscrowExpireTx,
+		CmdEscrowCreateHashlockTx,
+		CmdEscrowClaimTx,
+		CmdEscrowRefundTx,
+		CmdEscrowCreateStreamTx,
+		CmdEscrowWithdrawTx,
+		CmdEscrowCancelStreamTx,
+		CmdEscrowTopUpTx,
+		CmdEscrowAmendTx,
 		CmdEscrowQuery,
+		CmdEscrowList,
 	)
 
 	//register with main tx command
@@ -108,10 +269,34 @@ func cmdEscrowCreateTx(cmd *cobra.Command, args []string) error {
 		return errors.Errorf("Arbiter address is invalid hex: %v\n", err)
 	}
 
+	// convert the list of arbiters to bytes
+	var arbs [][]byte
+	if EscrowArbitersFlag != "" {
+		for _, arbHex := range strings.Split(EscrowArbitersFlag, ",") {
+			a, err := hex.DecodeString(bcmd.StripHex(strings.TrimSpace(arbHex)))
+			if err != nil {
+				return errors.Errorf("Arbiter address is invalid hex: %v\n", err)
+			}
+			arbs = append(arbs, a)
+		}
+	}
+
+	fee, err := bc.ParseCoins(EscrowArbFeeFlag)
+	if err != nil {
+		return err
+	}
+
 	tx := types.CreateEscrowTx{
-		Recipient:  recv,
-		Arbiter:    arb,
-		Expiration: EscrowExpireFlag,
+		Recipient:     recv,
+		Arbiter:       arb,
+		Arbiters:      arbs,
+		Threshold:     EscrowThresholdFlag,
+		Expiration:    EscrowExpireFlag,
+		ArbiterFee:    fee,
+		ArbiterFeeBps: EscrowArbFeeBpsFlag,
+
+		DecisionPeriod: EscrowPeriodFlag,
+		FallbackPayout: EscrowFallbackFlag,
 	}
 	data := types.EscrowTxBytes(tx)
 	return bcmd.AppTx(EscrowName, data)
@@ -125,6 +310,28 @@ func cmdEscrowResolveTx(cmd *cobra.Command, args []string) error {
 		return errors.Errorf("Recv address is invalid hex: %v\n", err)
 	}
 
+	// a compromise shares the money between both parties
+	if EscrowToRecvFlag != "" || EscrowToSendFlag != "" {
+		if EscrowPayoutFlag {
+			return fmt.Errorf("--abort-payout cannot be combined with --to-recipient or --to-sender") //never stack trace
+		}
+		toRecv, err := bc.ParseCoins(EscrowToRecvFlag)
+		if err != nil {
+			return err
+		}
+		toSend, err := bc.ParseCoins(EscrowToSendFlag)
+		if err != nil {
+			return err
+		}
+		tx := types.SplitEscrowTx{
+			Escrow:      addr,
+			ToRecipient: toRecv,
+			ToSender:    toSend,
+		}
+		data := types.EscrowTxBytes(tx)
+		return bcmd.AppTx(EscrowName, data)
+	}
+
 	tx := types.ResolveEscrowTx{
 		Escrow: addr,
 		Payout: !EscrowPayoutFlag,
@@ -133,6 +340,65 @@ func cmdEscrowResolveTx(cmd *cobra.Command, args []string) error {
 	return bcmd.AppTx(EscrowName, data)
 }
 
+func cmdEscrowReleaseTx(cmd *cobra.Command, args []string) error {
+
+	// convert the escrow address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(EscrowAddrFlag))
+	if err != nil {
+		return errors.Errorf("Escrow address is invalid hex: %v\n", err)
+	}
+
+	amount, err := bc.ParseCoins(EscrowReleaseFlag)
+	if err != nil {
+		return err
+	}
+
+	tx := types.ReleaseEscrowTx{
+		Escrow: addr,
+		Amount: amount,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
+func cmdEscrowApproveTx(cmd *cobra.Command, args []string) error {
+
+	// convert the escrow address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(EscrowAddrFlag))
+	if err != nil {
+		return errors.Errorf("Escrow address is invalid hex: %v\n", err)
+	}
+
+	tx := types.ApproveEscrowTx{
+		Escrow: addr,
+		Payout: !EscrowPayoutFlag,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
+func cmdEscrowDisputeTx(cmd *cobra.Command, args []string) error {
+
+	// convert the escrow address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(EscrowAddrFlag))
+	if err != nil {
+		return errors.Errorf("Escrow address is invalid hex: %v\n", err)
+	}
+
+	evidence, err := hex.DecodeString(bcmd.StripHex(EscrowEvidenceFlag))
+	if err != nil {
+		return errors.Errorf("Evidence is invalid hex: %v\n", err)
+	}
+
+	tx := types.DisputeEscrowTx{
+		Escrow:   addr,
+		Evidence: evidence,
+		Memo:     EscrowMemoFlag,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
 func cmdEscrowExpireTx(cmd *cobra.Command, args []string) error {
 
 	// convert destination address to bytes
@@ -148,6 +414,131 @@ func cmdEscrowExpireTx(cmd *cobra.Command, args []string) error {
 	return bcmd.AppTx(EscrowName, data)
 }
 
+func cmdEscrowCreateHashlockTx(cmd *cobra.Command, args []string) error {
+	// convert destination address to bytes
+	recv, err := hex.DecodeString(bcmd.StripHex(EscrowRecvFlag))
+	if err != nil {
+		return errors.Errorf("Recv address is invalid hex: %v\n", err)
+	}
+
+	hashlock, err := hex.DecodeString(bcmd.StripHex(EscrowHashlockFlag))
+	if err != nil {
+		return errors.Errorf("Hashlock is invalid hex: %v\n", err)
+	}
+
+	tx := types.CreateHashlockEscrowTx{
+		Recipient:  recv,
+		Hashlock:   hashlock,
+		Expiration: EscrowExpireFlag,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
+func cmdEscrowClaimTx(cmd *cobra.Command, args []string) error {
+
+	// convert the escrow address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(EscrowAddrFlag))
+	if err != nil {
+		return errors.Errorf("Escrow address is invalid hex: %v\n", err)
+	}
+
+	preimage, err := hex.DecodeString(bcmd.StripHex(EscrowPreimageFlag))
+	if err != nil {
+		return errors.Errorf("Preimage is invalid hex: %v\n", err)
+	}
+
+	tx := types.ClaimEscrowTx{
+		Escrow:   addr,
+		Preimage: preimage,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
+func cmdEscrowCreateStreamTx(cmd *cobra.Command, args []string) error {
+	// convert destination address to bytes
+	recv, err := hex.DecodeString(bcmd.StripHex(EscrowRecvFlag))
+	if err != nil {
+		return errors.Errorf("Recv address is invalid hex: %v\n", err)
+	}
+
+	tx := types.CreateStreamEscrowTx{
+		Recipient:   recv,
+		StartHeight: EscrowStartFlag,
+		EndHeight:   EscrowEndFlag,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
+func cmdEscrowWithdrawTx(cmd *cobra.Command, args []string) error {
+
+	// convert the escrow address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(EscrowAddrFlag))
+	if err != nil {
+		return errors.Errorf("Escrow address is invalid hex: %v\n", err)
+	}
+
+	tx := types.WithdrawEscrowTx{
+		Escrow: addr,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
+func cmdEscrowCancelStreamTx(cmd *cobra.Command, args []string) error {
+
+	// convert the escrow address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(EscrowAddrFlag))
+	if err != nil {
+		return errors.Errorf("Escrow address is invalid hex: %v\n", err)
+	}
+
+	tx := types.CancelStreamTx{
+		Escrow: addr,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
+func cmdEscrowTopUpTx(cmd *cobra.Command, args []string) error {
+
+	// convert the escrow address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(EscrowAddrFlag))
+	if err != nil {
+		return errors.Errorf("Escrow address is invalid hex: %v\n", err)
+	}
+
+	tx := types.TopUpEscrowTx{
+		Escrow: addr,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
+func cmdEscrowAmendTx(cmd *cobra.Command, args []string) error {
+
+	// convert the escrow address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(EscrowAddrFlag))
+	if err != nil {
+		return errors.Errorf("Escrow address is invalid hex: %v\n", err)
+	}
+
+	arb, err := hex.DecodeString(bcmd.StripHex(EscrowArbiterFlag))
+	if err != nil {
+		return errors.Errorf("Arbiter address is invalid hex: %v\n", err)
+	}
+
+	tx := types.AmendEscrowTx{
+		Escrow:     addr,
+		Expiration: EscrowExpireFlag,
+		Arbiter:    arb,
+	}
+	data := types.EscrowTxBytes(tx)
+	return bcmd.AppTx(EscrowName, data)
+}
+
 func cmdEscrowQuery(cmd *cobra.Command, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("account command requires an argument ([address])") //never stack trace
@@ -169,6 +560,63 @@ func cmdEscrowQuery(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// EscrowListItem is an escrow along with its address
+type EscrowListItem struct {
+	Address []byte
+	Escrow  *types.EscrowData
+}
+
+func cmdEscrowList(cmd *cobra.Command, args []string) error {
+	var key []byte
+	switch {
+	case EscrowListSendFlag != "":
+		addr, err := hex.DecodeString(bcmd.StripHex(EscrowListSendFlag))
+		if err != nil {
+			return errors.Errorf("Sender address is invalid hex: %v\n", err)
+		}
+		key = types.EscrowSenderKey(addr)
+	case EscrowListRecvFlag != "":
+		addr, err := hex.DecodeString(bcmd.StripHex(EscrowListRecvFlag))
+		if err != nil {
+			return errors.Errorf("Recipient address is invalid hex: %v\n", err)
+		}
+		key = types.EscrowRecipientKey(addr)
+	case EscrowListArbFlag != "":
+		addr, err := hex.DecodeString(bcmd.StripHex(EscrowListArbFlag))
+		if err != nil {
+			return errors.Errorf("Arbiter address is invalid hex: %v\n", err)
+		}
+		key = types.EscrowArbiterKey(addr)
+	default:
+		return fmt.Errorf("list requires one of --sender, --recipient or --arbiter") //never stack trace
+	}
+
+	prefix := []byte(fmt.Sprintf("%s/", EscrowName))
+	response, err := bcmd.Query(EscrowNodeFlag, append(prefix, key...))
+	if err != nil {
+		return err
+	}
+	var list types.AddrList
+	if len(response.Value) > 0 {
+		err = wire.ReadBinaryBytes(response.Value, &list)
+		if err != nil {
+			return errors.Errorf("Error reading escrow list: %v\n", err)
+		}
+	}
+
+	items := []EscrowListItem{}
+	for _, addr := range list {
+		esc, err := getEscrow(EscrowNodeFlag, addr)
+		if err != nil {
+			return err
+		}
+		items = append(items, EscrowListItem{Address: addr, Escrow: esc})
+	}
+
+	fmt.Println(string(wire.JSONBytes(items)))
+	return nil
+}
+
 func getEscrow(tmAddr string, address []byte) (*types.EscrowData, error) {
 	prefix := []byte(fmt.Sprintf("%s/", EscrowName))
 	key := append(prefix, address...)
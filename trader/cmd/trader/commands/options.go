@@ -23,7 +23,24 @@ var (
 	OptionAddrFlag        string
 	OptionExpireFlag      uint64
 	OptionSellToFlag      string
-	OptionTradeAmountFlag string
+	OptionTypeFlag        string
+	OptionUnderlyingFlag  string
+	OptionStrikeFlag      string
+	OptionUnitsFlag       uint64
+	OptionStartFlag       uint64
+	OptionEndFlag         uint64
+	OptionForSaleFlag     bool
+	OptionHolderFlag      string
+	OptionBondDenomFlag   string
+	OptionTradeDenomFlag  string
+	OptionMaxPriceFlag    string
+	OptionExpiresFlag     uint64
+	OptionBuyerFlag       string
+	OptionSortFlag        string
+	OptionReserveFlag     string
+	OptionAuctionEndFlag  uint64
+	OptionSaleExpireFlag  uint64
+	OptionHeightFlag      uint64
 	OptionPriceAmountFlag string
 
 	//commands
@@ -44,6 +61,12 @@ var (
 		RunE:  cmdOptionSellTx,
 	}
 
+	CmdOptionsCancelSaleTx = &cobra.Command{
+		Use:   "cancel-sale",
+		Short: "Withdraw the offer to sell this option",
+		RunE:  cmdOptionCancelSaleTx,
+	}
+
 	CmdOptionsBuyTx = &cobra.Command{
 		Use:   "buy",
 		Short: "Attempt to buy this option",
@@ -62,6 +85,24 @@ var (
 		RunE:  cmdOptionDissolveTx,
 	}
 
+	CmdOptionsAuctionTx = &cobra.Command{
+		Use:   "auction",
+		Short: "Auction this option to the highest bidder",
+		RunE:  cmdOptionAuctionTx,
+	}
+
+	CmdOptionsBidTx = &cobra.Command{
+		Use:   "bid",
+		Short: "Bid the money sent on an auctioned option",
+		RunE:  cmdOptionBidTx,
+	}
+
+	CmdOptionsList = &cobra.Command{
+		Use:   "list",
+		Short: "List the options for sale, or those held by an address",
+		RunE:  cmdOptionList,
+	}
+
 	CmdOptionsQuery = &cobra.Command{
 		Use:   "query [address]",
 		Short: "Return the contents of the given option",
@@ -73,8 +114,13 @@ func init() {
 
 	//Register Flags
 	createTxFlags := []bcmd.Flag2Register{
-		{&OptionExpireFlag, "expire", uint64(0), "The block height when the option expires"},
-		{&OptionTradeAmountFlag, "trade", "", "Amount of coins to trade in format <amt><coin>,<amt2><coin2>,..."},
+		{&OptionNodeFlag, "node", "tcp://localhost:46657", "Tendermint RPC address"},
+		{&OptionExpireFlag, "expire", uint64(0), "The block height when the option expires (required)"},
+		{&OptionTypeFlag, "type", "call", "The option type, call to buy the underlying or put to sell it"},
+		{&OptionUnderlyingFlag, "underlying", "", "The coins the option is written on in format <amt><coin>"},
+		{&OptionStrikeFlag, "strike", "", "Price for one unit of the underlying in format <amt><coin>"},
+		{&OptionStartFlag, "exercise-start", uint64(0), "The first block height the option can be exercised (0 for any)"},
+		{&OptionEndFlag, "exercise-end", uint64(0), "The last block height the option can be exercised (0 for any)"},
 	}
 	addrFlag := bcmd.Flag2Register{
 		&OptionAddrFlag, "option", "", "The address of this option"}
@@ -82,33 +128,66 @@ func init() {
 		addrFlag,
 		{&OptionSellToFlag, "sellto", "", "Who to sell the options to (optional)"},
 		{&OptionPriceAmountFlag, "price", "", "Price to buy option in format <amt><coin>,<amt2><coin2>,..."},
+		{&OptionSaleExpireFlag, "sale-expire", uint64(0), "The last block height the option can be bought (0 for no limit)"},
+	}
+	cancelSaleTxFlags := []bcmd.Flag2Register{
+		addrFlag,
 	}
 	buyTxFlags := []bcmd.Flag2Register{
 		addrFlag,
 	}
 	exerciseTxFlags := []bcmd.Flag2Register{
 		addrFlag,
+		{&OptionUnitsFlag, "units", uint64(0), "Units of the underlying to exercise (0 for all remaining)"},
 	}
 	dissolveTxFlags := []bcmd.Flag2Register{
 		addrFlag,
 	}
+	auctionTxFlags := []bcmd.Flag2Register{
+		addrFlag,
+		{&OptionReserveFlag, "reserve", "", "The lowest bid accepted in format <amt><coin>"},
+		{&OptionAuctionEndFlag, "end", uint64(0), "The last block height to bid, the auction settles at its end"},
+	}
+	bidTxFlags := []bcmd.Flag2Register{
+		addrFlag,
+	}
 	queryFlags := []bcmd.Flag2Register{
 		{&OptionNodeFlag, "node", "tcp://localhost:46657", "Tendermint RPC address"},
 	}
+	listFlags := []bcmd.Flag2Register{
+		{&OptionNodeFlag, "node", "tcp://localhost:46657", "Tendermint RPC address"},
+		{&OptionForSaleFlag, "for-sale", false, "List the options offered for sale"},
+		{&OptionHolderFlag, "holder", "", "List the options held by this address"},
+		{&OptionBondDenomFlag, "bond-denom", "", "Only options bonding this denomination"},
+		{&OptionTradeDenomFlag, "trade-denom", "", "Only options traded for this denomination"},
+		{&OptionMaxPriceFlag, "max-price", "", "Only options priced at most <amt><coin>,<amt2><coin2>,..."},
+		{&OptionExpiresFlag, "expires-after", uint64(0), "Only options that do not expire before this height"},
+		{&OptionBuyerFlag, "buyer", "", "Only sales this address can buy (default any sale)"},
+		{&OptionHeightFlag, "height", uint64(0), "Hide the offers and options expired at this block height (0 for the next block)"},
+		{&OptionSortFlag, "sort", "", "Sort the options by price, bond, trade or expiry"},
+	}
 	bcmd.RegisterFlags(CmdOptionsCreateTx, createTxFlags)
 	bcmd.RegisterFlags(CmdOptionsSellTx, sellTxFlags)
+	bcmd.RegisterFlags(CmdOptionsCancelSaleTx, cancelSaleTxFlags)
 	bcmd.RegisterFlags(CmdOptionsBuyTx, buyTxFlags)
 	bcmd.RegisterFlags(CmdOptionsExerciseTx, exerciseTxFlags)
 	bcmd.RegisterFlags(CmdOptionsDissolveTx, dissolveTxFlags)
+	bcmd.RegisterFlags(CmdOptionsAuctionTx, auctionTxFlags)
+	bcmd.RegisterFlags(CmdOptionsBidTx, bidTxFlags)
+	bcmd.RegisterFlags(CmdOptionsList, listFlags)
 	bcmd.RegisterFlags(CmdOptionsQuery, queryFlags)
 
 	//Register Subcommands
 	CmdOptionsTx.AddCommand(
 		CmdOptionsCreateTx,
 		CmdOptionsSellTx,
+		CmdOptionsCancelSaleTx,
 		CmdOptionsBuyTx,
 		CmdOptionsExerciseTx,
 		CmdOptionsDissolveTx,
+		CmdOptionsAuctionTx,
+		CmdOptionsBidTx,
+		CmdOptionsList,
 		CmdOptionsQuery,
 	)
 
@@ -117,16 +196,63 @@ func init() {
 		func() bc.Plugin { return options.New(OptionName) })
 }
 
+// parseSingleCoin parses a flag with exactly one coin, an option
+// is written on a single coin and priced in another one
+func parseSingleCoin(flag, value string) (bc.Coin, error) {
+	coins, err := bc.ParseCoins(value)
+	if err != nil {
+		return bc.Coin{}, err
+	}
+	if len(coins) != 1 {
+		return bc.Coin{}, fmt.Errorf("--%s takes exactly one coin, create one option per coin", flag) //never stack trace
+	}
+	return coins[0], nil
+}
+
 func cmdOptionCreateTx(cmd *cobra.Command, args []string) error {
 
-	tradeCoins, err := bc.ParseCoins(OptionTradeAmountFlag)
+	typ, err := types.ParseOptionType(OptionTypeFlag)
+	if err != nil {
+		return err
+	}
+	underlying, err := parseSingleCoin("underlying", OptionUnderlyingFlag)
+	if err != nil {
+		return err
+	}
+	strike, err := parseSingleCoin("strike", OptionStrikeFlag)
 	if err != nil {
 		return err
 	}
 
 	tx := types.CreateOptionTx{
+		Type:       typ,
+		Underlying: underlying,
+		Strike:     strike,
 		Expiration: OptionExpireFlag,
-		Trade:      tradeCoins,
+
+		ExerciseStart: OptionStartFlag,
+		ExerciseEnd:   OptionEndFlag,
+	}
+	// catch what the plugin would reject, before paying for the tx
+	if err = tx.ValidateBasic(); err != nil {
+		return err
+	}
+	// the tx is run in the next block at the earliest
+	height, err := getHeight(OptionNodeFlag)
+	if err != nil {
+		return err
+	}
+	if err = tx.CheckHeight(height + 1); err != nil {
+		return err
+	}
+	if f := cmd.Flag("amount"); f != nil {
+		amount, err := bc.ParseCoins(f.Value.String())
+		if err != nil {
+			return err
+		}
+		if _, err = tx.CheckBond(amount); err != nil {
+			return err
+		}
 	}
 	data := types.OptionsTxBytes(tx)
 	return bcmd.AppTx(OptionName, data)
@@ -151,9 +277,25 @@ func cmdOptionSellTx(cmd *cobra.Command, args []string) error {
 	}
 
 	tx := types.SellOptionTx{
-		Addr:      addr,
-		NewHolder: buyer,
-		Price:     priceCoins,
+		Addr:       addr,
+		NewHolder:  buyer,
+		Price:      priceCoins,
+		Expiration: OptionSaleExpireFlag,
+	}
+	data := types.OptionsTxBytes(tx)
+	return bcmd.AppTx(OptionName, data)
+}
+
+func cmdOptionCancelSaleTx(cmd *cobra.Command, args []string) error {
+
+	// convert the option address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(OptionAddrFlag))
+	if err != nil {
+		return errors.Errorf("Option address is invalid hex: %v\n", err)
+	}
+
+	tx := types.CancelSaleTx{
+		Addr: addr,
 	}
 	data := types.OptionsTxBytes(tx)
 	return bcmd.AppTx(OptionName, data)
@@ -183,7 +325,8 @@ func cmdOptionExerciseTx(cmd *cobra.Command, args []string) error {
 	}
 
 	tx := types.ExerciseOptionTx{
-		Addr: addr,
+		Addr:  addr,
+		Units: int64(OptionUnitsFlag),
 	}
 	data := types.OptionsTxBytes(tx)
 	return bcmd.AppTx(OptionName, data)
@@ -224,6 +367,167 @@ func cmdOptionQuery(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func cmdOptionAuctionTx(cmd *cobra.Command, args []string) error {
+
+	// convert the option address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(OptionAddrFlag))
+	if err != nil {
+		return errors.Errorf("Option address is invalid hex: %v\n", err)
+	}
+
+	reserve, err := bc.ParseCoin(OptionReserveFlag)
+	if err != nil {
+		return err
+	}
+
+	tx := types.AuctionOptionTx{
+		Addr:    addr,
+		Reserve: reserve,
+		End:     OptionAuctionEndFlag,
+	}
+	data := types.OptionsTxBytes(tx)
+	return bcmd.AppTx(OptionName, data)
+}
+
+func cmdOptionBidTx(cmd *cobra.Command, args []string) error {
+
+	// convert the option address to bytes
+	addr, err := hex.DecodeString(bcmd.StripHex(OptionAddrFlag))
+	if err != nil {
+		return errors.Errorf("Option address is invalid hex: %v\n", err)
+	}
+
+	tx := types.BidOptionTx{
+		Addr: addr,
+	}
+	data := types.OptionsTxBytes(tx)
+	return bcmd.AppTx(OptionName, data)
+}
+
+func cmdOptionList(cmd *cobra.Command, args []string) error {
+	switch {
+	case OptionForSaleFlag && OptionHolderFlag != "":
+		return fmt.Errorf("list takes either --for-sale or --holder") //never stack trace
+	case OptionForSaleFlag:
+		return listForSale()
+	case OptionHolderFlag != "":
+		addr, err := hex.DecodeString(bcmd.StripHex(OptionHolderFlag))
+		if err != nil {
+			return errors.Errorf("Holder address is invalid hex: %v\n", err)
+		}
+		return listHolder(addr)
+	default:
+		return fmt.Errorf("list requires one of --for-sale or --holder") //never stack trace
+	}
+}
+
+// listHolder prints all options of the holder
+func listHolder(addr []byte) error {
+	prefix := []byte(fmt.Sprintf("%s/", OptionName))
+	response, err := bcmd.Query(OptionNodeFlag, append(prefix, types.OptionHolderKey(addr)...))
+	if err != nil {
+		return err
+	}
+	var list types.AddrList
+	if len(response.Value) > 0 {
+		err = wire.ReadBinaryBytes(response.Value, &list)
+		if err != nil {
+			return errors.Errorf("Error reading option list: %v\n", err)
+		}
+	}
+	items, err := loadListings(list)
+	if err != nil {
+		return err
+	}
+	return printListings(items)
+}
+
+// listForSale narrows the offers down to what the buyer looks for on
+// the sale index, and only loads the options that can match. By default
+// only what can still be bought in the next block is listed
+func listForSale() error {
+	filter := types.SaleFilter{
+		BondDenom:     OptionBondDenomFlag,
+		TradeDenom:    OptionTradeDenomFlag,
+		ExpiresAfter:  OptionExpiresFlag,
+		IncludeClosed: OptionBuyerFlag == "",
+		Height:        OptionHeightFlag,
+	}
+	var err error
+	if OptionMaxPriceFlag != "" {
+		filter.MaxPrice, err = bc.ParseCoins(OptionMaxPriceFlag)
+		if err != nil {
+			return err
+		}
+	}
+	if OptionBuyerFlag != "" {
+		filter.Buyer, err = hex.DecodeString(bcmd.StripHex(OptionBuyerFlag))
+		if err != nil {
+			return errors.Errorf("Buyer address is invalid hex: %v\n", err)
+		}
+	}
+
+	prefix := []byte(fmt.Sprintf("%s/", OptionName))
+	response, err := bcmd.Query(OptionNodeFlag, append(prefix, filter.IndexKey()...))
+	if err != nil {
+		return err
+	}
+	if filter.Height == 0 {
+		filter.Height = response.Height + 1
+	}
+	var idx types.SaleIndex
+	if len(response.Value) > 0 {
+		err = wire.ReadBinaryBytes(response.Value, &idx)
+		if err != nil {
+			return errors.Errorf("Error reading sale index: %v\n", err)
+		}
+	}
+
+	var list types.AddrList
+	for _, entry := range filter.FilterEntries(idx) {
+		list = append(list, entry.Address)
+	}
+	items, err := loadListings(list)
+	if err != nil {
+		return err
+	}
+	return printListings(filter.Filter(items))
+}
+
+func loadListings(list types.AddrList) ([]types.OptionListing, error) {
+	items := []types.OptionListing{}
+	for _, addr := range list {
+		opt, err := getOption(OptionNodeFlag, addr)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, types.OptionListing{Address: addr, Option: *opt})
+	}
+	return items, nil
+}
+
+// printListings prints the listings as JSON, in the order of the
+// --sort flag if given
+func printListings(items []types.OptionListing) error {
+	if OptionSortFlag != "" {
+		if err := types.SortListings(items, OptionSortFlag); err != nil {
+			return err
+		}
+	}
+	fmt.Println(string(wire.JSONBytes(items)))
+	return nil
+}
+
+// getHeight is the last block height of the node, as reported by a query
+func getHeight(tmAddr string) (uint64, error) {
+	prefix := []byte(fmt.Sprintf("%s/", OptionName))
+	response, err := bcmd.Query(tmAddr, append(prefix, types.OptionsForSaleKey...))
+	if err != nil {
+		return 0, err
+	}
+	return response.Height, nil
+}
+
 func getOption(tmAddr string, address []byte) (*types.OptionData, error) {
 	prefix := []byte(fmt.Sprintf("%s/", OptionName))
 	key := append(prefix, address...)
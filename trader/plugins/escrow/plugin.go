@@ -9,6 +9,13 @@ import (
 	bc "github.com/tendermint/basecoin/types"
 )
 
+// MaxExpirePerBlock limits how many escrows are expired in EndBlock,
+// any more are left for the following blocks
+const MaxExpirePerBlock = 100
+
+// expirations indexes the escrows by their expiration height
+var expirations = types.NewHeightIndex("expire/")
+
 // Plugin is a plugin, storing all state prefixed with it's unique name
 type Plugin struct {
 	name   string
@@ -58,6 +65,28 @@ func (p Plugin) Exec(store bc.KVStore, ctx bc.CallContext, tx types.EscrowTx) ab
 		return p.runResolveEscrow(pstore, accts, ctx, t)
 	case types.ExpireEscrowTx:
 		return p.runExpireEscrow(pstore, accts, ctx, t)
+	case types.ReleaseEscrowTx:
+		return p.runReleaseEscrow(pstore, accts, ctx, t)
+	case types.SplitEscrowTx:
+		return p.runSplitEscrow(pstore, accts, ctx, t)
+	case types.CreateHashlockEscrowTx:
+		return p.runCreateHashlockEscrow(pstore, accts, ctx, t)
+	case types.ClaimEscrowTx:
+		return p.runClaimEscrow(pstore, accts, ctx, t)
+	case types.ApproveEscrowTx:
+		return p.runApproveEscrow(pstore, accts, ctx, t)
+	case types.DisputeEscrowTx:
+		return p.runDisputeEscrow(pstore, accts, ctx, t)
+	case types.CreateStreamEscrowTx:
+		return p.runCreateStreamEscrow(pstore, accts, ctx, t)
+	case types.WithdrawEscrowTx:
+		return p.runWithdrawEscrow(pstore, accts, ctx, t)
+	case types.CancelStreamTx:
+		return p.runCancelStream(pstore, accts, ctx, t)
+	case types.TopUpEscrowTx:
+		return p.runTopUpEscrow(pstore, accts, ctx, t)
+	case types.AmendEscrowTx:
+		return p.runAmendEscrow(pstore, accts, ctx, t)
 	default:
 		return abci.ErrUnknownRequest
 	}
@@ -71,11 +100,30 @@ func (p *Plugin) BeginBlock(store bc.KVStore, hash []byte, header *abci.Header)
 	p.height = header.Height
 }
 
+// return the money of expired escrows to the sender and track the height
 func (p *Plugin) EndBlock(store bc.KVStore, height uint64) abci.ResponseEndBlock {
+	p.expireEscrows(store, height)
 	p.height = height + 1
 	return abci.ResponseEndBlock{}
 }
 
+// expireEscrows settles escrows that expired before this block, or
+// whose dispute deadline passed, just as an ExpireEscrowTx would
+func (p Plugin) expireEscrows(store bc.KVStore, height uint64) {
+	accts := trader.NewAccountant(store)
+	pstore := p.prefix(store)
+
+	for _, addr := range expirations.Pop(pstore, height, MaxExpirePerBlock) {
+		// skip the ones already settled by a tx
+		esc, err := types.LoadEscrow(pstore, addr)
+		if err != nil || !esc.CanExpire(height) {
+			continue
+		}
+		accts.Pay(esc.ExpirePayee(), esc.Amount)
+		removeEscrow(pstore, addr, esc)
+	}
+}
+
 func (p *Plugin) assertPlugin() bc.Plugin {
 	return p
 }
@@ -2,6 +2,7 @@ package escrow
 
 import (
 	"bytes"
+	"crypto/sha256"
 
 	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/basecoin-examples/trader"
@@ -10,20 +11,89 @@ import (
 	bc "github.com/tendermint/basecoin/types"
 )
 
+// checkCallerAccount makes sure there is an account whose sequence
+// makes the address of a new escrow unique, refunding if not
+func checkCallerAccount(accts trader.Accountant, ctx bc.CallContext) abci.Result {
+	if ctx.CallerAccount == nil {
+		accts.Refund(ctx)
+		return abci.ErrBaseUnknownAddress.AppendLog("Creating an escrow requires a caller account")
+	}
+	return abci.OK
+}
+
 func (p Plugin) runCreateEscrow(store bc.KVStore,
 	accts trader.Accountant,
 	ctx bc.CallContext,
 	tx types.CreateEscrowTx) abci.Result {
 	// TODO: require fees? limit the size of the escrow?
 
+	if res := checkCallerAccount(accts, ctx); res.IsErr() {
+		return res
+	}
 	data := types.EscrowData{
 		Sender:     ctx.CallerAddress,
+		Serial:     ctx.CallerAccount.Sequence,
 		Recipient:  tx.Recipient,
 		Arbiter:    tx.Arbiter,
+		Arbiters:   tx.Arbiters,
+		Threshold:  tx.Threshold,
+		Expiration: tx.Expiration,
+		Amount:     ctx.Coins,
+
+		DecisionPeriod: tx.DecisionPeriod,
+		FallbackPayout: tx.FallbackPayout,
+	}
+	// make sure all settings are valid, if not abort and return money
+	if data.IsExpired(p.height) {
+		accts.Refund(ctx)
+		return abci.NewError(abci.CodeType_BaseInvalidInput, "Escrow already expired")
+	}
+	if len(data.Recipient) != 20 {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Invalid recipient address")
+	}
+	if res := validateArbiters(data); res.IsErr() {
+		accts.Refund(ctx)
+		return res
+	}
+	fee, res := arbiterFee(data, tx)
+	if res.IsErr() {
+		accts.Refund(ctx)
+		return res
+	}
+	data.ArbiterFee = fee
+	data.ArbiterFeeBps = tx.ArbiterFeeBps
+
+	// create the escrow contract
+	addr, res := saveNewEscrow(store, data)
+	if res.IsErr() {
+		accts.Refund(ctx)
+		return res
+	}
+	return abci.NewResultOK(addr, "Created Escrow")
+}
+
+func (p Plugin) runCreateHashlockEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.CreateHashlockEscrowTx) abci.Result {
+
+	if res := checkCallerAccount(accts, ctx); res.IsErr() {
+		return res
+	}
+	data := types.EscrowData{
+		Sender:     ctx.CallerAddress,
+		Serial:     ctx.CallerAccount.Sequence,
+		Recipient:  tx.Recipient,
 		Expiration: tx.Expiration,
 		Amount:     ctx.Coins,
+		Hashlock:   tx.Hashlock,
 	}
 	// make sure all settings are valid, if not abort and return money
+	if data.Expiration == 0 {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Hashlock escrow requires an expiration")
+	}
 	if data.IsExpired(p.height) {
 		accts.Refund(ctx)
 		return abci.NewError(abci.CodeType_BaseInvalidInput, "Escrow already expired")
@@ -32,39 +102,393 @@ func (p Plugin) runCreateEscrow(store bc.KVStore,
 		accts.Refund(ctx)
 		return abci.ErrBaseInvalidInput.AppendLog("Invalid recipient address")
 	}
-	if len(data.Arbiter) != 20 {
+	if len(data.Hashlock) != sha256.Size {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Hashlock must be a sha256 hash")
+	}
+
+	// create the escrow contract
+	addr, res := saveNewEscrow(store, data)
+	if res.IsErr() {
+		accts.Refund(ctx)
+		return res
+	}
+	return abci.NewResultOK(addr, "Created Escrow")
+}
+
+func (p Plugin) runCreateStreamEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.CreateStreamEscrowTx) abci.Result {
+
+	if res := checkCallerAccount(accts, ctx); res.IsErr() {
+		return res
+	}
+	data := types.EscrowData{
+		Sender:      ctx.CallerAddress,
+		Serial:      ctx.CallerAccount.Sequence,
+		Recipient:   tx.Recipient,
+		Amount:      ctx.Coins,
+		StreamStart: tx.StartHeight,
+		StreamEnd:   tx.EndHeight,
+	}
+	// make sure all settings are valid, if not abort and return money
+	if data.StreamStart >= data.StreamEnd {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Stream must end after it starts")
+	}
+	if data.StreamEnd <= p.height {
 		accts.Refund(ctx)
-		return abci.ErrBaseInvalidInput.AppendLog("Invalid arbiter address")
+		return abci.ErrBaseInvalidInput.AppendLog("Stream already ended")
+	}
+	if len(data.Recipient) != 20 {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Invalid recipient address")
 	}
 
 	// create the escrow contract
+	addr, res := saveNewEscrow(store, data)
+	if res.IsErr() {
+		accts.Refund(ctx)
+		return res
+	}
+	return abci.NewResultOK(addr, "Created Escrow")
+}
+
+// saveNewEscrow stores a new escrow and indexes it for expiration
+// and by all parties, it never overwrites an existing escrow
+func saveNewEscrow(store bc.KVStore, data types.EscrowData) ([]byte, abci.Result) {
 	addr := data.Address()
+	if len(store.Get(addr)) > 0 {
+		return nil, abci.ErrBaseDuplicateAddress.AppendLog("Escrow already exists at this address")
+	}
 	store.Set(addr, data.Bytes())
-	return abci.NewResultOK(addr, "Created Escrow")
+	indexEscrow(store, addr, data)
+	return addr, abci.OK
 }
 
-func (p Plugin) runResolveEscrow(store bc.KVStore,
+// indexEscrow adds the escrow to the expiry index and those of all parties
+func indexEscrow(store bc.KVStore, addr []byte, esc types.EscrowData) {
+	if esc.ExpiryHeight() != 0 {
+		expirations.Add(store, esc.ExpiryHeight(), addr)
+	}
+	for _, key := range esc.IndexKeys() {
+		types.LoadAddrList(store, key).Add(addr).Save(store, key)
+	}
+}
+
+// unindexEscrow removes the escrow from all indexes
+func unindexEscrow(store bc.KVStore, addr []byte, esc types.EscrowData) {
+	if esc.ExpiryHeight() != 0 {
+		expirations.Remove(store, esc.ExpiryHeight(), addr)
+	}
+	for _, key := range esc.IndexKeys() {
+		types.LoadAddrList(store, key).Remove(addr).Save(store, key)
+	}
+}
+
+// validateArbiters checks there is either one arbiter, or a list of
+// distinct arbiters with a threshold that can be reached
+func validateArbiters(data types.EscrowData) abci.Result {
+	if !data.HasArbiters() {
+		if len(data.Arbiter) != 20 {
+			return abci.ErrBaseInvalidInput.AppendLog("Invalid arbiter address")
+		}
+		return abci.OK
+	}
+
+	if len(data.Arbiter) != 0 {
+		return abci.ErrBaseInvalidInput.AppendLog("Set either one arbiter or a list of arbiters")
+	}
+	for i, arb := range data.Arbiters {
+		if len(arb) != 20 {
+			return abci.ErrBaseInvalidInput.AppendLog("Invalid arbiter address")
+		}
+		for _, other := range data.Arbiters[:i] {
+			if bytes.Equal(arb, other) {
+				return abci.ErrBaseInvalidInput.AppendLog("Duplicate arbiter address")
+			}
+		}
+	}
+	if data.Threshold < 1 || data.Threshold > len(data.Arbiters) {
+		return abci.ErrBaseInvalidInput.AppendLog("Threshold must be between 1 and the number of arbiters")
+	}
+	return abci.OK
+}
+
+// arbiterFee calculates the fixed or relative fee of the arbiter and
+// makes sure it can be paid from the escrow
+func arbiterFee(data types.EscrowData, tx types.CreateEscrowTx) (bc.Coins, abci.Result) {
+	fee := tx.ArbiterFee
+	if tx.ArbiterFeeBps != 0 {
+		if len(fee) > 0 {
+			return nil, abci.ErrBaseInvalidInput.AppendLog("Set either a fixed or a relative arbiter fee")
+		}
+		if tx.ArbiterFeeBps < 0 || tx.ArbiterFeeBps > types.MaxBasisPoints {
+			return nil, abci.ErrBaseInvalidInput.AppendLog("Arbiter fee must be between 0 and 10000 basis points")
+		}
+		fee = types.BasisPoints(data.Amount, tx.ArbiterFeeBps)
+	}
+
+	if fee.IsZero() && tx.ArbiterFeeBps == 0 {
+		return nil, abci.OK
+	}
+	if data.HasArbiters() {
+		return nil, abci.ErrBaseInvalidInput.AppendLog("Arbiter fee requires a single arbiter")
+	}
+	if !fee.IsValid() || !fee.IsNonnegative() {
+		return nil, abci.ErrBaseInvalidInput.AppendLog("Arbiter fee must be valid and nonnegative")
+	}
+	if !data.Amount.IsGTE(fee) {
+		return nil, abci.ErrInsufficientFunds.AppendLog("Arbiter fee exceeds the escrow amount")
+	}
+	return fee, abci.OK
+}
+
+// payArbiterFee pays the arbiter for resolving the escrow
+func payArbiterFee(accts trader.Accountant, esc types.EscrowData) {
+	if !esc.ArbiterFee.IsZero() {
+		accts.Pay(esc.Arbiter, esc.ArbiterFee)
+	}
+}
+
+// removeEscrow wipes out a settled escrow, also from all indexes
+func removeEscrow(store bc.KVStore, addr []byte, esc types.EscrowData) {
+	store.Set(addr, nil)
+	unindexEscrow(store, addr, esc)
+}
+
+// loadEscrow returns the escrow stored at addr, if there is none the
+// caller is refunded and an error result returned
+func loadEscrow(store bc.KVStore,
 	accts trader.Accountant,
 	ctx bc.CallContext,
-	tx types.ResolveEscrowTx) abci.Result {
-	// first load the data
-	data := store.Get(tx.Escrow)
+	addr []byte) (types.EscrowData, abci.Result) {
+
+	data := store.Get(addr)
 	if len(data) == 0 { // nil and []byte{}
 		accts.Refund(ctx)
-		return abci.ErrBaseUnknownAddress
+		return types.EscrowData{}, abci.ErrBaseUnknownAddress
 	}
 
 	esc, err := types.ParseEscrow(data)
 	if err != nil {
 		accts.Refund(ctx)
-		return abci.NewError(abci.CodeType_BaseEncodingError, "Cannot parse data at location")
+		return types.EscrowData{}, abci.NewError(abci.CodeType_BaseEncodingError, "Cannot parse data at location")
+	}
+	return esc, abci.OK
+}
+
+func (p Plugin) runResolveEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.ResolveEscrowTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
+	}
+
+	// only the Arbiter can resolve, and only before the dispute deadline
+	if !esc.IsArbiter(ctx.CallerAddress) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized
+	}
+	if esc.IsDisputed() && esc.CanExpire(p.height) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized.AppendLog("Dispute deadline passed")
+	}
+
+	// with multiple arbiters, wait until enough of them agree
+	if esc.HasArbiters() {
+		vote := types.ArbiterVote{Arbiter: ctx.CallerAddress, Payout: tx.Payout}
+		if esc.AddVote(vote) < esc.Threshold {
+			store.Set(tx.Escrow, esc.Bytes())
+			return abci.OK.AppendLog("Arbiter vote recorded")
+		}
+	}
+
+	// Okay, now let's resolve this transaction!
+	payArbiterFee(accts, esc)
+	if tx.Payout {
+		accts.Pay(esc.Recipient, esc.AmountAfterFee())
+	} else {
+		accts.Pay(esc.Sender, esc.AmountAfterFee())
+	}
+
+	// wipe out the escrow and return the payment
+	removeEscrow(store, tx.Escrow, esc)
+	return abci.OK.AppendLog("Escrow settled")
+}
+
+func (p Plugin) runExpireEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.ExpireEscrowTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
+	}
+
+	// only resolve if expired, or the arbiter missed the dispute deadline
+	if !esc.CanExpire(p.height) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized
+	}
+
+	// wipe out the escrow and return the payment to sender,
+	// or whoever the fallback rule of a dispute says
+	accts.Pay(esc.ExpirePayee(), esc.Amount)
+	removeEscrow(store, tx.Escrow, esc)
+	return abci.OK.AppendLog("Escrow expired")
+}
+
+func (p Plugin) runReleaseEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.ReleaseEscrowTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
+	}
+
+	// only the Arbiter can release, not a vote of multiple arbiters,
+	// and a dispute must be resolved first
+	if esc.HasArbiters() {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow with multiple arbiters cannot be released")
+	}
+	if esc.IsDisputed() {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Disputed escrow cannot be released")
+	}
+	if !bytes.Equal(ctx.CallerAddress, esc.Arbiter) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized
+	}
+
+	// we can only release what is still locked
+	if !tx.Amount.IsValid() || !tx.Amount.IsPositive() {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Release amount must be positive")
+	}
+	if !esc.AmountAfterFee().IsGTE(tx.Amount) {
+		accts.Refund(ctx)
+		return abci.ErrInsufficientFunds.AppendLog("Release exceeds the remaining escrow after the arbiter fee")
+	}
+
+	// pay the milestone and keep the rest locked
+	accts.Pay(esc.Recipient, tx.Amount)
+	esc.Amount = esc.Amount.Minus(tx.Amount)
+	if esc.Amount.IsZero() {
+		removeEscrow(store, tx.Escrow, esc)
+		return abci.OK.AppendLog("Escrow settled")
+	}
+	esc.Released = esc.Released.Plus(tx.Amount)
+	store.Set(tx.Escrow, esc.Bytes())
+	return abci.OK.AppendLog("Escrow partially released")
+}
+
+func (p Plugin) runSplitEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.SplitEscrowTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
 	}
 
-	// only the Arbiter can resolve
+	// only the Arbiter can split, not a vote of multiple arbiters
+	if esc.HasArbiters() {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow with multiple arbiters cannot be split")
+	}
 	if !bytes.Equal(ctx.CallerAddress, esc.Arbiter) {
 		accts.Refund(ctx)
 		return abci.ErrUnauthorized
 	}
+	if esc.IsDisputed() && esc.CanExpire(p.height) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized.AppendLog("Dispute deadline passed")
+	}
+
+	// the split must cover exactly what is left in the escrow
+	for _, coins := range []bc.Coins{tx.ToRecipient, tx.ToSender} {
+		if !coins.IsValid() || !coins.IsNonnegative() {
+			accts.Refund(ctx)
+			return abci.ErrBaseInvalidInput.AppendLog("Split amounts must be valid and nonnegative")
+		}
+	}
+	if !tx.ToRecipient.Plus(tx.ToSender).IsEqual(esc.AmountAfterFee()) {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Split amounts must sum to the escrow amount after the arbiter fee")
+	}
+
+	// Okay, now let's share the money
+	payArbiterFee(accts, esc)
+	accts.Pay(esc.Recipient, tx.ToRecipient)
+	accts.Pay(esc.Sender, tx.ToSender)
+
+	// wipe out the escrow and return the payment
+	removeEscrow(store, tx.Escrow, esc)
+	return abci.OK.AppendLog("Escrow settled")
+}
+
+func (p Plugin) runClaimEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.ClaimEscrowTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
+	}
+
+	// only the Recipient can claim, and only before it expires
+	if !esc.IsHashlock() {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow has no hashlock")
+	}
+	if !bytes.Equal(ctx.CallerAddress, esc.Recipient) || esc.IsExpired(p.height) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized
+	}
+	if !esc.CheckPreimage(tx.Preimage) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized.AppendLog("Preimage does not match the hashlock")
+	}
+
+	// wipe out the escrow and pay the recipient
+	accts.Pay(esc.Recipient, esc.Amount)
+	removeEscrow(store, tx.Escrow, esc)
+	return abci.OK.AppendLog("Escrow claimed")
+}
+
+func (p Plugin) runApproveEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.ApproveEscrowTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
+	}
+
+	// only the Sender and Recipient can approve
+	if !esc.IsParty(ctx.CallerAddress) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized
+	}
+
+	// wait until both of them agree
+	approval := types.Approval{Party: ctx.CallerAddress, Payout: tx.Payout}
+	if !esc.AddApproval(approval) {
+		store.Set(tx.Escrow, esc.Bytes())
+		return abci.OK.AppendLog("Approval recorded")
+	}
 
 	// Okay, now let's resolve this transaction!
 	if tx.Payout {
@@ -74,35 +498,229 @@ func (p Plugin) runResolveEscrow(store bc.KVStore,
 	}
 
 	// wipe out the escrow and return the payment
-	store.Set(tx.Escrow, nil)
+	removeEscrow(store, tx.Escrow, esc)
 	return abci.OK.AppendLog("Escrow settled")
 }
 
-func (p Plugin) runExpireEscrow(store bc.KVStore,
+func (p Plugin) runDisputeEscrow(store bc.KVStore,
 	accts trader.Accountant,
 	ctx bc.CallContext,
-	tx types.ExpireEscrowTx) abci.Result {
+	tx types.DisputeEscrowTx) abci.Result {
 	// first load the data
-	data := store.Get(tx.Escrow)
-	if len(data) == 0 { // nil and []byte{}
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
+	}
+
+	// only the Sender and Recipient can raise a dispute
+	if !esc.IsParty(ctx.CallerAddress) {
 		accts.Refund(ctx)
-		return abci.ErrBaseUnknownAddress
+		return abci.ErrUnauthorized
 	}
 
-	esc, err := types.ParseEscrow(data)
-	if err != nil {
+	// and only once, if the escrow allows it
+	switch {
+	case esc.IsHashlock() || esc.DecisionPeriod == 0:
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow does not allow disputes")
+	case esc.IsDisputed():
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow is already disputed")
+	case esc.IsExpired(p.height):
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow already expired")
+	case len(tx.Evidence) > types.MaxEvidenceLength:
 		accts.Refund(ctx)
-		return abci.NewError(abci.CodeType_BaseEncodingError, "Cannot parse data at location")
+		return abci.ErrBaseInvalidInput.AppendLog("Evidence is too long")
+	case len(tx.Memo) > types.MaxMemoLength:
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Memo is too long")
+	}
+
+	// from now on, the escrow expires at the deadline of the dispute
+	if esc.Expiration != 0 {
+		expirations.Remove(store, esc.Expiration, tx.Escrow)
+	}
+	esc.State = types.EscrowDisputed
+	esc.Dispute = types.EscrowDispute{
+		Raiser:   ctx.CallerAddress,
+		Evidence: tx.Evidence,
+		Memo:     tx.Memo,
+		Deadline: p.height + esc.DecisionPeriod,
+	}
+	expirations.Add(store, esc.Dispute.Deadline, tx.Escrow)
+	store.Set(tx.Escrow, esc.Bytes())
+	return abci.OK.AppendLog("Dispute raised")
+}
+
+func (p Plugin) runWithdrawEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.WithdrawEscrowTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
 	}
 
-	// only resolve if expired
-	if !esc.IsExpired(p.height) {
+	// only the Recipient can withdraw from a stream
+	if !esc.IsStream() {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow is no stream")
+	}
+	if !bytes.Equal(ctx.CallerAddress, esc.Recipient) {
 		accts.Refund(ctx)
 		return abci.ErrUnauthorized
 	}
+	amount := esc.Withdrawable(p.height)
+	if amount.IsZero() {
+		accts.Refund(ctx)
+		return abci.ErrInsufficientFunds.AppendLog("Nothing vested to withdraw")
+	}
 
-	// wipe out the escrow and return the payment to sender
-	accts.Pay(esc.Sender, esc.Amount)
-	store.Set(tx.Escrow, nil)
-	return abci.OK.AppendLog("Escrow expired")
+	// pay what is vested and keep the rest streaming
+	accts.Pay(esc.Recipient, amount)
+	esc.Amount = esc.Amount.Minus(amount)
+	if esc.Amount.IsZero() {
+		removeEscrow(store, tx.Escrow, esc)
+		return abci.OK.AppendLog("Escrow settled")
+	}
+	esc.Released = esc.Released.Plus(amount)
+	store.Set(tx.Escrow, esc.Bytes())
+	return abci.OK.AppendLog("Vested coins withdrawn")
+}
+
+func (p Plugin) runCancelStream(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.CancelStreamTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
+	}
+
+	// only the Sender can cancel a stream
+	if !esc.IsStream() {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow is no stream")
+	}
+	if !bytes.Equal(ctx.CallerAddress, esc.Sender) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized
+	}
+
+	// the recipient keeps what is vested, the sender gets the rest
+	vested := esc.Withdrawable(p.height)
+	accts.Pay(esc.Recipient, vested)
+	accts.Pay(esc.Sender, esc.Amount.Minus(vested))
+
+	// wipe out the escrow
+	removeEscrow(store, tx.Escrow, esc)
+	return abci.OK.AppendLog("Stream cancelled")
+}
+
+func (p Plugin) runTopUpEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.TopUpEscrowTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
+	}
+
+	// only the Sender can add money to a running escrow
+	if !bytes.Equal(ctx.CallerAddress, esc.Sender) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized
+	}
+	switch {
+	case esc.IsExpired(p.height):
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow already expired")
+	case esc.IsStream():
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Stream cannot be topped up")
+	case !ctx.Coins.IsValid() || !ctx.Coins.IsPositive():
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Top-up amount must be positive")
+	}
+
+	// the coins sent are locked with the rest, a relative fee
+	// is charged on everything the escrow was funded with
+	esc.Amount = esc.Amount.Plus(ctx.Coins)
+	if esc.ArbiterFeeBps != 0 {
+		esc.ArbiterFee = types.BasisPoints(esc.Amount.Plus(esc.Released), esc.ArbiterFeeBps)
+	}
+	store.Set(tx.Escrow, esc.Bytes())
+	return abci.OK.AppendLog("Escrow topped up")
+}
+
+func (p Plugin) runAmendEscrow(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.AmendEscrowTx) abci.Result {
+	// first load the data
+	esc, res := loadEscrow(store, accts, ctx, tx.Escrow)
+	if res.IsErr() {
+		return res
+	}
+
+	// only the Sender and Recipient can amend
+	if !esc.IsParty(ctx.CallerAddress) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized
+	}
+
+	// make sure the amendment makes sense for this escrow
+	switch {
+	case len(tx.Arbiter) == 0 && tx.Expiration == 0:
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Nothing to amend")
+	case esc.IsExpired(p.height) || esc.IsDisputed() || esc.IsStream():
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow cannot be amended")
+	case tx.Expiration != 0 && esc.Expiration != 0 && tx.Expiration <= esc.Expiration:
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Expiration can only be extended")
+	case tx.Expiration != 0 && esc.Expiration == 0:
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Escrow never expires")
+	case len(tx.Arbiter) != 0 && (len(esc.Arbiter) == 0 || len(tx.Arbiter) != 20):
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog("Only a single arbiter can be changed to a valid address")
+	}
+
+	// extending the expiration is up to the sender, a new arbiter
+	// needs the recipient to confirm the same amendment
+	amend := types.EscrowAmendment{
+		Proposer:   ctx.CallerAddress,
+		Expiration: tx.Expiration,
+		Arbiter:    tx.Arbiter,
+	}
+	if len(tx.Arbiter) == 0 {
+		if !bytes.Equal(ctx.CallerAddress, esc.Sender) {
+			accts.Refund(ctx)
+			return abci.ErrUnauthorized
+		}
+	} else if !esc.Amendment.Confirms(amend) {
+		esc.Amendment = amend
+		store.Set(tx.Escrow, esc.Bytes())
+		return abci.OK.AppendLog("Amendment proposed")
+	}
+
+	// update the escrow in place, along with the indexes
+	unindexEscrow(store, tx.Escrow, esc)
+	if tx.Expiration != 0 {
+		esc.Expiration = tx.Expiration
+	}
+	// only a confirmed arbiter change closes the pending proposal
+	if len(tx.Arbiter) != 0 {
+		esc.Arbiter = tx.Arbiter
+		esc.Amendment = types.EscrowAmendment{}
+	}
+	indexEscrow(store, tx.Escrow, esc)
+	store.Set(tx.Escrow, esc.Bytes())
+	return abci.OK.AppendLog("Escrow amended")
 }
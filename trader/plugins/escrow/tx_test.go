@@ -1,9 +1,11 @@
 package escrow
 
 import (
+	"crypto/sha256"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/basecoin-examples/trader"
 	"github.com/tendermint/basecoin-examples/trader/types"
 	bc "github.com/tendermint/basecoin/types"
@@ -38,6 +40,9 @@ func TestTransactions(t *testing.T) {
 	ctx := bc.CallContext{
 		CallerAddress: sender,
 		Coins:         money,
+		CallerAccount: &bc.Account{
+			Sequence: 1,
+		},
 	}
 	plugin := Plugin{
 		height: 123,
@@ -125,3 +130,898 @@ func TestTransactions(t *testing.T) {
 	esc, err = types.LoadEscrow(store, addr)
 	assert.NotNil(err)
 }
+
+// newTestPlugin is an escrow plugin at height 100 with an empty store
+func newTestPlugin() (Plugin, bc.KVStore, bc.KVStore, trader.Accountant) {
+	store := bc.NewMemKVStore()
+	plugin := Plugin{
+		height: 100,
+		name:   "escrow",
+	}
+	return plugin, store, plugin.prefix(store), trader.NewAccountant(store)
+}
+
+// createCtx sends coins from the caller, whose account has the sequence
+// that makes the address of a new escrow unique
+func createCtx(caller []byte, coins bc.Coins, seq int) bc.CallContext {
+	return bc.CallContext{
+		CallerAddress: caller,
+		Coins:         coins,
+		CallerAccount: &bc.Account{Sequence: seq},
+	}
+}
+
+func TestCreateWithoutAccount(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+	hash := sha256.Sum256([]byte("secret"))
+
+	plugin, store, _, accts := newTestPlugin()
+
+	// without an account there is no sequence for the address,
+	// so every kind of escrow is rejected and the money returned
+	ctx := bc.CallContext{
+		CallerAddress: sender,
+		Coins:         money,
+	}
+	txs := []types.EscrowTx{
+		types.CreateEscrowTx{Recipient: recv, Arbiter: arb},
+		types.CreateHashlockEscrowTx{Recipient: recv, Hashlock: hash[:], Expiration: 200},
+		types.CreateStreamEscrowTx{Recipient: recv, StartHeight: 100, EndHeight: 200},
+	}
+	for i, tx := range txs {
+		res := plugin.Exec(store, ctx, tx)
+		assert.Equal(abci.CodeType_BaseUnknownAddress, res.Code, "%d", i)
+	}
+	expected := money.Plus(money).Plus(money)
+	assert.Equal(expected, accts.GetAccount(sender).Balance)
+}
+
+func TestPartialRelease(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+	milestone := bc.Coins{{Amount: 400, Denom: "ATOM"}}
+	rest := bc.Coins{{Amount: 600, Denom: "ATOM"}}
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	// create the escrow
+	ctx := createCtx(sender, money, 1)
+	tx := types.CreateEscrowTx{
+		Recipient:  recv,
+		Arbiter:    arb,
+		Expiration: 500,
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// only the arbiter can release
+	ctx = bc.CallContext{
+		CallerAddress: recv,
+	}
+	rtx := types.ReleaseEscrowTx{
+		Escrow: addr,
+		Amount: milestone,
+	}
+	res = plugin.Exec(store, ctx, rtx)
+	assert.True(res.IsErr())
+
+	// and never more than what is left
+	ctx = bc.CallContext{
+		CallerAddress: arb,
+	}
+	for _, amount := range []bc.Coins{nil, money.Plus(milestone), {{Amount: 10, Denom: "BTC"}}} {
+		res = plugin.Exec(store, ctx, types.ReleaseEscrowTx{Escrow: addr, Amount: amount})
+		assert.True(res.IsErr(), amount.String())
+	}
+
+	// release the first milestone
+	res = plugin.Exec(store, ctx, rtx)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(milestone, accts.GetAccount(recv).Balance)
+	esc, err := types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(rest, esc.Amount)
+		assert.Equal(milestone, esc.Released)
+	}
+
+	// the rest can still be returned to the sender
+	rtx2 := types.ResolveEscrowTx{
+		Escrow: addr,
+		Payout: false,
+	}
+	res = plugin.Exec(store, ctx, rtx2)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(rest, accts.GetAccount(sender).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+
+	// releasing everything closes the escrow
+	ctx = createCtx(sender, money, 2)
+	tx.Expiration = 600
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	ctx = bc.CallContext{
+		CallerAddress: arb,
+	}
+	res = plugin.Exec(store, ctx, types.ReleaseEscrowTx{Escrow: addr, Amount: milestone})
+	assert.True(res.IsOK(), res.Log)
+	res = plugin.Exec(store, ctx, types.ReleaseEscrowTx{Escrow: addr, Amount: rest})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(money.Plus(milestone), accts.GetAccount(recv).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+}
+
+func TestSplitResolution(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}, {Amount: 20, Denom: "BTC"}}
+	toRecv := bc.Coins{{Amount: 700, Denom: "ATOM"}, {Amount: 20, Denom: "BTC"}}
+	toSend := bc.Coins{{Amount: 300, Denom: "ATOM"}}
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	ctx := createCtx(sender, money, 1)
+	tx := types.CreateEscrowTx{
+		Recipient: recv,
+		Arbiter:   arb,
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// only the arbiter can split
+	stx := types.SplitEscrowTx{
+		Escrow:      addr,
+		ToRecipient: toRecv,
+		ToSender:    toSend,
+	}
+	ctx = bc.CallContext{
+		CallerAddress: sender,
+	}
+	res = plugin.Exec(store, ctx, stx)
+	assert.True(res.IsErr())
+
+	// and the amounts must match the escrow
+	ctx = bc.CallContext{
+		CallerAddress: arb,
+	}
+	bad := []types.SplitEscrowTx{
+		{Escrow: addr, ToRecipient: toRecv},
+		{Escrow: addr, ToRecipient: toRecv, ToSender: toRecv},
+		{Escrow: addr, ToRecipient: money.Plus(toSend), ToSender: toSend.Negative()},
+	}
+	for i, btx := range bad {
+		res = plugin.Exec(store, ctx, btx)
+		assert.True(res.IsErr(), "%d", i)
+	}
+	_, err := types.LoadEscrow(pstore, addr)
+	assert.Nil(err)
+
+	// now we share the money
+	res = plugin.Exec(store, ctx, stx)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(toRecv, accts.GetAccount(recv).Balance)
+	assert.Equal(toSend, accts.GetAccount(sender).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+}
+
+func TestMultiArbiter(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv := cmn.RandBytes(20), cmn.RandBytes(20)
+	arb1, arb2, arb3 := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	// invalid arbiter settings are rejected and refunded
+	ctx := createCtx(sender, money, 1)
+	arbs := [][]byte{arb1, arb2, arb3}
+	bad := []types.CreateEscrowTx{
+		{Recipient: recv, Arbiters: arbs},
+		{Recipient: recv, Arbiters: arbs, Threshold: 4},
+		{Recipient: recv, Arbiters: arbs, Threshold: 2, Arbiter: arb1},
+		{Recipient: recv, Arbiters: [][]byte{arb1, arb2, arb1}, Threshold: 2},
+		{Recipient: recv, Arbiters: [][]byte{arb1, arb2[:10]}, Threshold: 1},
+	}
+	var refunded bc.Coins
+	for i, btx := range bad {
+		res := plugin.Exec(store, ctx, btx)
+		assert.True(res.IsErr(), "%d", i)
+		refunded = refunded.Plus(money)
+		assert.Equal(refunded, accts.GetAccount(sender).Balance, "%d", i)
+	}
+
+	// 2 of 3 arbiters must agree
+	tx := types.CreateEscrowTx{
+		Recipient: recv,
+		Arbiters:  arbs,
+		Threshold: 2,
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// only arbiters can vote
+	rtx := types.ResolveEscrowTx{Escrow: addr, Payout: true}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, rtx)
+	assert.True(res.IsErr())
+
+	// and there is no release or split with multiple arbiters
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb1},
+		types.ReleaseEscrowTx{Escrow: addr, Amount: money})
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb1},
+		types.SplitEscrowTx{Escrow: addr, ToRecipient: money})
+	assert.True(res.IsErr())
+
+	// the first votes disagree, so they are only recorded
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb1}, rtx)
+	assert.True(res.IsOK(), res.Log)
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb2},
+		types.ResolveEscrowTx{Escrow: addr, Payout: false})
+	assert.True(res.IsOK(), res.Log)
+	esc, err := types.LoadEscrow(pstore, addr)
+	assert.Nil(err)
+	assert.Equal(2, len(esc.Votes))
+	assert.True(accts.GetAccount(recv).Balance.IsZero())
+
+	// voting again does not count twice
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb1}, rtx)
+	assert.True(res.IsOK(), res.Log)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.Nil(err)
+
+	// once two agree, the escrow is settled
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb3}, rtx)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(money, accts.GetAccount(recv).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+}
+
+func TestEndBlockExpiry(t *testing.T) {
+	assert := assert.New(t)
+	sender, arb := cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	// more escrows than expire in one block, and one that is resolved
+	var addrs [][]byte
+	for i := 0; i < MaxExpirePerBlock+2; i++ {
+		ctx := createCtx(sender, money, i+1)
+		tx := types.CreateEscrowTx{
+			Recipient:  cmn.RandBytes(20),
+			Arbiter:    arb,
+			Expiration: uint64(200 + i%2),
+		}
+		res := plugin.Exec(store, ctx, tx)
+		assert.True(res.IsOK(), res.Log)
+		addrs = append(addrs, res.Data)
+	}
+	rtx := types.ResolveEscrowTx{Escrow: addrs[0], Payout: false}
+	res := plugin.Exec(store, bc.CallContext{CallerAddress: arb}, rtx)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(money, accts.GetAccount(sender).Balance)
+
+	// nothing happens before the expiration
+	plugin.EndBlock(store, 200)
+	assert.Equal(money, accts.GetAccount(sender).Balance)
+	assert.Equal(uint64(201), plugin.height)
+
+	// then at most MaxExpirePerBlock are returned per block
+	plugin.EndBlock(store, 202)
+	expired := int64(MaxExpirePerBlock + 1)
+	assert.Equal(money[0].Amount*expired, accts.GetAccount(sender).Balance[0].Amount)
+	_, err := types.LoadEscrow(pstore, addrs[1])
+	assert.NotNil(err)
+	_, err = types.LoadEscrow(pstore, addrs[len(addrs)-1])
+	assert.Nil(err)
+
+	// and the rest in the next one
+	plugin.EndBlock(store, 203)
+	expired = int64(MaxExpirePerBlock + 2)
+	assert.Equal(money[0].Amount*expired, accts.GetAccount(sender).Balance[0].Amount)
+	_, err = types.LoadEscrow(pstore, addrs[len(addrs)-1])
+	assert.NotNil(err)
+}
+
+func TestHashlockEscrow(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv := cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+	preimage := []byte("my secret")
+	hash := sha256.Sum256(preimage)
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	// a hashlock and an expiration are required
+	ctx := createCtx(sender, money, 1)
+	bad := []types.CreateHashlockEscrowTx{
+		{Recipient: recv, Hashlock: hash[:]},
+		{Recipient: recv, Hashlock: hash[:], Expiration: 50},
+		{Recipient: recv, Hashlock: preimage, Expiration: 200},
+		{Recipient: recv[:10], Hashlock: hash[:], Expiration: 200},
+	}
+	for i, btx := range bad {
+		res := plugin.Exec(store, ctx, btx)
+		assert.True(res.IsErr(), "%d", i)
+	}
+
+	tx := types.CreateHashlockEscrowTx{
+		Recipient:  recv,
+		Hashlock:   hash[:],
+		Expiration: 200,
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// there is no arbiter to resolve it
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender},
+		types.ResolveEscrowTx{Escrow: addr, Payout: false})
+	assert.True(res.IsErr())
+
+	// only the recipient can claim, and only with the right preimage
+	ctx = bc.CallContext{CallerAddress: sender}
+	res = plugin.Exec(store, ctx, types.ClaimEscrowTx{Escrow: addr, Preimage: preimage})
+	assert.True(res.IsErr())
+	ctx = bc.CallContext{CallerAddress: recv}
+	res = plugin.Exec(store, ctx, types.ClaimEscrowTx{Escrow: addr, Preimage: hash[:]})
+	assert.True(res.IsErr())
+	_, err := types.LoadEscrow(pstore, addr)
+	assert.Nil(err)
+
+	// the sender cannot refund before the expiration
+	etx := types.ExpireEscrowTx{Escrow: addr}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, etx)
+	assert.True(res.IsErr())
+
+	// the recipient reveals the secret and gets paid
+	res = plugin.Exec(store, ctx, types.ClaimEscrowTx{Escrow: addr, Preimage: preimage})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(money, accts.GetAccount(recv).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+
+	// another swap is not claimed in time, so the sender gets a refund
+	ctx = createCtx(sender, money, 2)
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	plugin.height = 201
+	ctx = bc.CallContext{CallerAddress: recv}
+	res = plugin.Exec(store, ctx, types.ClaimEscrowTx{Escrow: addr, Preimage: preimage})
+	assert.True(res.IsErr())
+	sb := accts.GetAccount(sender).Balance
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, types.ExpireEscrowTx{Escrow: addr})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(sb.Plus(money), accts.GetAccount(sender).Balance)
+}
+
+func TestMutualConsent(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	ctx := createCtx(sender, money, 1)
+	tx := types.CreateEscrowTx{
+		Recipient: recv,
+		Arbiter:   arb,
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// the arbiter is not a party
+	cancel := types.ApproveEscrowTx{Escrow: addr, Payout: false}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb}, cancel)
+	assert.True(res.IsErr())
+
+	// parties disagree, nothing happens
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, cancel)
+	assert.True(res.IsOK(), res.Log)
+	payout := types.ApproveEscrowTx{Escrow: addr, Payout: true}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, payout)
+	assert.True(res.IsOK(), res.Log)
+	esc, err := types.LoadEscrow(pstore, addr)
+	assert.Nil(err)
+	assert.Equal(2, len(esc.Approvals))
+
+	// approving twice alone is not enough
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, payout)
+	assert.True(res.IsOK(), res.Log)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.Nil(err)
+
+	// recipient agrees to cancel, the sender gets the money back
+	sb := accts.GetOrCreateAccount(sender).Balance
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, cancel)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(sb.Plus(money), accts.GetAccount(sender).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+}
+
+func TestArbiterFee(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+	fee := bc.Coins{{Amount: 20, Denom: "ATOM"}}
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	// the fee must be sensible and fit in the escrow
+	ctx := createCtx(sender, money, 1)
+	bad := []types.CreateEscrowTx{
+		{Recipient: recv, Arbiter: arb, ArbiterFee: money.Plus(fee)},
+		{Recipient: recv, Arbiter: arb, ArbiterFee: bc.Coins{{Amount: 5, Denom: "BTC"}}},
+		{Recipient: recv, Arbiter: arb, ArbiterFee: fee.Negative()},
+		{Recipient: recv, Arbiter: arb, ArbiterFeeBps: types.MaxBasisPoints + 1},
+		{Recipient: recv, Arbiter: arb, ArbiterFeeBps: -5},
+		{Recipient: recv, Arbiter: arb, ArbiterFee: fee, ArbiterFeeBps: 200},
+		{Recipient: recv, Arbiters: [][]byte{arb, sender}, Threshold: 1, ArbiterFee: fee},
+	}
+	for i, btx := range bad {
+		res := plugin.Exec(store, ctx, btx)
+		assert.True(res.IsErr(), "%d", i)
+	}
+	sb := accts.GetAccount(sender).Balance
+
+	// 200 basis points are 20 ATOM
+	tx := types.CreateEscrowTx{
+		Recipient:     recv,
+		Arbiter:       arb,
+		ArbiterFeeBps: 200,
+		Expiration:    200,
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+	esc, err := types.LoadEscrow(pstore, addr)
+	assert.Nil(err)
+	assert.Equal(fee, esc.ArbiterFee)
+
+	// a release must leave the fee locked
+	ctx = bc.CallContext{CallerAddress: arb}
+	res = plugin.Exec(store, ctx, types.ReleaseEscrowTx{Escrow: addr, Amount: money})
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, ctx, types.ReleaseEscrowTx{Escrow: addr, Amount: money.Minus(fee)})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(money.Minus(fee), accts.GetAccount(recv).Balance)
+
+	// resolving pays the fee to the arbiter
+	res = plugin.Exec(store, ctx, types.ResolveEscrowTx{Escrow: addr, Payout: false})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(fee, accts.GetAccount(arb).Balance)
+	assert.Equal(sb, accts.GetAccount(sender).Balance)
+
+	// splits pay the fee as well
+	ctx = createCtx(sender, money, 2)
+	tx = types.CreateEscrowTx{
+		Recipient:  recv,
+		Arbiter:    arb,
+		ArbiterFee: fee,
+	}
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	half := bc.Coins{{Amount: 490, Denom: "ATOM"}}
+	ctx = bc.CallContext{CallerAddress: arb}
+	res = plugin.Exec(store, ctx, types.SplitEscrowTx{Escrow: addr, ToRecipient: half, ToSender: half.Plus(fee)})
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, ctx, types.SplitEscrowTx{Escrow: addr, ToRecipient: half, ToSender: half})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(fee.Plus(fee), accts.GetAccount(arb).Balance)
+	assert.Equal(sb.Plus(half), accts.GetAccount(sender).Balance)
+
+	// but there is no fee if the escrow expires
+	ctx = createCtx(sender, money, 3)
+	tx = types.CreateEscrowTx{
+		Recipient:  recv,
+		Arbiter:    arb,
+		ArbiterFee: fee,
+		Expiration: 300,
+	}
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	plugin.height = 301
+	sb = accts.GetAccount(sender).Balance
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, types.ExpireEscrowTx{Escrow: addr})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(sb.Plus(money), accts.GetAccount(sender).Balance)
+	assert.Equal(fee.Plus(fee), accts.GetAccount(arb).Balance)
+
+	// a fee in basis points grows with a top-up, a fixed one does not
+	ctx = createCtx(sender, money, 4)
+	tx = types.CreateEscrowTx{
+		Recipient:     recv,
+		Arbiter:       arb,
+		ArbiterFeeBps: 200,
+	}
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	more := bc.Coins{{Amount: 500, Denom: "ATOM"}, {Amount: 100, Denom: "BTC"}}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender, Coins: more}, types.TopUpEscrowTx{Escrow: addr})
+	assert.True(res.IsOK(), res.Log)
+	esc, err = types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(200, esc.ArbiterFeeBps)
+		assert.Equal(bc.Coins{{Amount: 30, Denom: "ATOM"}, {Amount: 2, Denom: "BTC"}}, esc.ArbiterFee)
+	}
+
+	ctx.CallerAccount = &bc.Account{Sequence: 5}
+	tx = types.CreateEscrowTx{
+		Recipient:  recv,
+		Arbiter:    arb,
+		ArbiterFee: fee,
+	}
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender, Coins: more}, types.TopUpEscrowTx{Escrow: addr})
+	assert.True(res.IsOK(), res.Log)
+	esc, err = types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(fee, esc.ArbiterFee)
+	}
+}
+
+func TestPartyIndexes(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb, arb2 := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+
+	plugin, store, pstore, _ := newTestPlugin()
+
+	ctx := createCtx(sender, money, 1)
+	res := plugin.Exec(store, ctx, types.CreateEscrowTx{Recipient: recv, Arbiter: arb})
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+	ctx.CallerAccount = &bc.Account{Sequence: 2}
+	res = plugin.Exec(store, ctx, types.CreateEscrowTx{
+		Recipient:  recv,
+		Arbiters:   [][]byte{arb, arb2},
+		Threshold:  1,
+		Expiration: 200,
+	})
+	assert.True(res.IsOK(), res.Log)
+	addr2 := res.Data
+
+	// every party finds their escrows
+	assert.Equal(types.AddrList{addr, addr2}, types.LoadAddrList(pstore, types.EscrowSenderKey(sender)))
+	assert.Equal(types.AddrList{addr, addr2}, types.LoadAddrList(pstore, types.EscrowRecipientKey(recv)))
+	assert.Equal(types.AddrList{addr, addr2}, types.LoadAddrList(pstore, types.EscrowArbiterKey(arb)))
+	assert.Equal(types.AddrList{addr2}, types.LoadAddrList(pstore, types.EscrowArbiterKey(arb2)))
+	assert.Empty(types.LoadAddrList(pstore, types.EscrowSenderKey(recv)))
+
+	// settled escrows are removed from all lists
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb}, types.ResolveEscrowTx{Escrow: addr, Payout: true})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(types.AddrList{addr2}, types.LoadAddrList(pstore, types.EscrowSenderKey(sender)))
+	assert.Equal(types.AddrList{addr2}, types.LoadAddrList(pstore, types.EscrowRecipientKey(recv)))
+	assert.Equal(types.AddrList{addr2}, types.LoadAddrList(pstore, types.EscrowArbiterKey(arb)))
+
+	// also when they expire in EndBlock
+	plugin.EndBlock(store, 201)
+	assert.Empty(types.LoadAddrList(pstore, types.EscrowSenderKey(sender)))
+	assert.Empty(types.LoadAddrList(pstore, types.EscrowRecipientKey(recv)))
+	assert.Empty(types.LoadAddrList(pstore, types.EscrowArbiterKey(arb)))
+	assert.Empty(types.LoadAddrList(pstore, types.EscrowArbiterKey(arb2)))
+	assert.Nil(pstore.Get(types.EscrowSenderKey(sender)))
+}
+
+func TestUniqueAddresses(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	// two identical escrows in different txs get different addresses
+	tx := types.CreateEscrowTx{
+		Recipient: recv,
+		Arbiter:   arb,
+	}
+	ctx := createCtx(sender, money, 1)
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+	assert.Equal(types.EscrowAddress(sender, 1), addr)
+	ctx.CallerAccount = &bc.Account{Sequence: 2}
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr2 := res.Data
+	assert.NotEqual(addr, addr2)
+
+	// both hold their own money
+	for _, a := range [][]byte{addr, addr2} {
+		esc, err := types.LoadEscrow(pstore, a)
+		if assert.Nil(err) {
+			assert.Equal(money, esc.Amount)
+		}
+	}
+
+	// an existing escrow is never overwritten
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsErr())
+	assert.Equal(money, accts.GetAccount(sender).Balance)
+	esc, err := types.LoadEscrow(pstore, addr2)
+	if assert.Nil(err) {
+		assert.Equal(money, esc.Amount)
+	}
+}
+
+func TestDisputes(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+	evidence := cmn.RandBytes(32)
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	// no disputes without a decision period
+	ctx := createCtx(sender, money, 1)
+	res := plugin.Exec(store, ctx, types.CreateEscrowTx{Recipient: recv, Arbiter: arb})
+	assert.True(res.IsOK(), res.Log)
+	dtx := types.DisputeEscrowTx{Escrow: res.Data, Evidence: evidence}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, dtx)
+	assert.True(res.IsErr())
+
+	// the arbiter gets 20 blocks, else the recipient is paid
+	ctx.CallerAccount = &bc.Account{Sequence: 2}
+	tx := types.CreateEscrowTx{
+		Recipient:      recv,
+		Arbiter:        arb,
+		Expiration:     110,
+		DecisionPeriod: 20,
+		FallbackPayout: true,
+	}
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// only the parties can raise a dispute, with limited data
+	dtx = types.DisputeEscrowTx{Escrow: addr, Evidence: evidence, Memo: "never delivered"}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb}, dtx)
+	assert.True(res.IsErr())
+	long := types.DisputeEscrowTx{Escrow: addr, Evidence: cmn.RandBytes(types.MaxEvidenceLength + 1)}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, long)
+	assert.True(res.IsErr())
+
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, dtx)
+	assert.True(res.IsOK(), res.Log)
+	esc, err := types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(types.EscrowDisputed, esc.State)
+		assert.Equal(recv, esc.Dispute.Raiser)
+		assert.Equal(evidence, esc.Dispute.Evidence)
+		assert.Equal("never delivered", esc.Dispute.Memo)
+		assert.Equal(uint64(120), esc.Dispute.Deadline)
+	}
+
+	// only once, and no more releases
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, dtx)
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb},
+		types.ReleaseEscrowTx{Escrow: addr, Amount: money})
+	assert.True(res.IsErr())
+
+	// the expiration no longer applies, only the deadline
+	plugin.height = 115
+	etx := types.ExpireEscrowTx{Escrow: addr}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, etx)
+	assert.True(res.IsErr())
+	plugin.EndBlock(store, 115)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.Nil(err)
+
+	// after the deadline the arbiter is too late
+	plugin.height = 121
+	rtx := types.ResolveEscrowTx{Escrow: addr, Payout: false}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb}, rtx)
+	assert.True(res.IsErr())
+
+	// and the fallback rule pays the recipient
+	plugin.EndBlock(store, 121)
+	assert.Equal(money, accts.GetAccount(recv).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+	assert.False(types.LoadAddrList(pstore, types.EscrowSenderKey(sender)).Has(addr))
+
+	// but an arbiter in time decides as usual
+	plugin.height = 130
+	ctx.CallerAccount = &bc.Account{Sequence: 3}
+	tx.Expiration = 0
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	dtx.Escrow = addr
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, dtx)
+	assert.True(res.IsOK(), res.Log)
+	rtx.Escrow = addr
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb}, rtx)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(money, accts.GetAccount(recv).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+}
+
+func TestStreamEscrow(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv := cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	// the stream must end in the future
+	ctx := createCtx(sender, money, 1)
+	bad := []types.CreateStreamEscrowTx{
+		{Recipient: recv, StartHeight: 200, EndHeight: 200},
+		{Recipient: recv, StartHeight: 50, EndHeight: 90},
+		{Recipient: recv[:10], StartHeight: 100, EndHeight: 200},
+	}
+	for i, btx := range bad {
+		res := plugin.Exec(store, ctx, btx)
+		assert.True(res.IsErr(), "%d", i)
+	}
+	sb := accts.GetAccount(sender).Balance
+
+	tx := types.CreateStreamEscrowTx{
+		Recipient:   recv,
+		StartHeight: 100,
+		EndHeight:   200,
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// nothing vested yet, and only the recipient can withdraw
+	wtx := types.WithdrawEscrowTx{Escrow: addr}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, wtx)
+	assert.True(res.IsErr())
+	plugin.height = 130
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, wtx)
+	assert.True(res.IsErr())
+
+	// withdraw the first 30%
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, wtx)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(bc.Coins{{Amount: 300, Denom: "ATOM"}}, accts.GetAccount(recv).Balance)
+	esc, err := types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(bc.Coins{{Amount: 700, Denom: "ATOM"}}, esc.Amount)
+		assert.Equal(bc.Coins{{Amount: 300, Denom: "ATOM"}}, esc.Released)
+	}
+
+	// and not twice
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, wtx)
+	assert.True(res.IsErr())
+
+	// only the sender can cancel, the recipient keeps what vested
+	plugin.height = 150
+	ctx = bc.CallContext{CallerAddress: recv}
+	ctsx := types.CancelStreamTx{Escrow: addr}
+	res = plugin.Exec(store, ctx, ctsx)
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, ctsx)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(bc.Coins{{Amount: 500, Denom: "ATOM"}}, accts.GetAccount(recv).Balance)
+	assert.Equal(sb.Plus(bc.Coins{{Amount: 500, Denom: "ATOM"}}), accts.GetAccount(sender).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+
+	// after the end, everything can be withdrawn at once
+	ctx = createCtx(sender, money, 2)
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	plugin.height = 250
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, types.WithdrawEscrowTx{Escrow: addr})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(money.Plus(bc.Coins{{Amount: 500, Denom: "ATOM"}}), accts.GetAccount(recv).Balance)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.NotNil(err)
+}
+
+func TestTopUpAndAmend(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb, arb2 := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	money := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
+	more := bc.Coins{{Amount: 500, Denom: "ATOM"}, {Amount: 5, Denom: "BTC"}}
+
+	plugin, store, pstore, accts := newTestPlugin()
+
+	ctx := createCtx(sender, money, 1)
+	tx := types.CreateEscrowTx{
+		Recipient:  recv,
+		Arbiter:    arb,
+		Expiration: 200,
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// only the sender can add money
+	ttx := types.TopUpEscrowTx{Escrow: addr}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv, Coins: more}, ttx)
+	assert.True(res.IsErr())
+	assert.Equal(more, accts.GetAccount(recv).Balance)
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender, Coins: more}, ttx)
+	assert.True(res.IsOK(), res.Log)
+	esc, err := types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(money.Plus(more), esc.Amount)
+	}
+
+	// only the sender can extend the expiration, never shorten it
+	amtx := types.AmendEscrowTx{Escrow: addr, Expiration: 300}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, amtx)
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender},
+		types.AmendEscrowTx{Escrow: addr, Expiration: 150})
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, amtx)
+	assert.True(res.IsOK(), res.Log)
+	esc, err = types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(uint64(300), esc.Expiration)
+	}
+
+	// so it no longer expires at the old height
+	plugin.EndBlock(store, 250)
+	_, err = types.LoadEscrow(pstore, addr)
+	assert.Nil(err)
+
+	// a new arbiter needs both parties to agree
+	amtx = types.AmendEscrowTx{Escrow: addr, Arbiter: arb2}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb}, amtx)
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, amtx)
+	assert.True(res.IsOK(), res.Log)
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender}, amtx)
+	assert.True(res.IsOK(), res.Log)
+	esc, err = types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(arb, esc.Arbiter)
+		assert.Equal(sender, esc.Amendment.Proposer)
+	}
+
+	// extending the expiration meanwhile keeps the proposal open
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: sender},
+		types.AmendEscrowTx{Escrow: addr, Expiration: 400})
+	assert.True(res.IsOK(), res.Log)
+	esc, err = types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(uint64(400), esc.Expiration)
+		assert.Equal(arb2, esc.Amendment.Arbiter)
+	}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: recv}, amtx)
+	assert.True(res.IsOK(), res.Log)
+	esc, err = types.LoadEscrow(pstore, addr)
+	if assert.Nil(err) {
+		assert.Equal(arb2, esc.Arbiter)
+		assert.Empty(esc.Amendment.Proposer)
+	}
+	assert.False(types.LoadAddrList(pstore, types.EscrowArbiterKey(arb)).Has(addr))
+	assert.True(types.LoadAddrList(pstore, types.EscrowArbiterKey(arb2)).Has(addr))
+
+	// so only the new arbiter decides, at the same address
+	rtx := types.ResolveEscrowTx{Escrow: addr, Payout: true}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb}, rtx)
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: arb2}, rtx)
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(more.Plus(money).Plus(more), accts.GetAccount(recv).Balance)
+}
@@ -9,6 +9,34 @@ import (
 	bc "github.com/tendermint/basecoin/types"
 )
 
+// MaxDissolvePerBlock limits how many expired options are dissolved in
+// EndBlock, any more are left for the following blocks
+const MaxDissolvePerBlock = 100
+
+// MaxAuctionsPerBlock limits how many ended auctions are settled in
+// EndBlock, any more are left for the following blocks
+const MaxAuctionsPerBlock = 100
+
+// error codes of the options plugin, one for each reason
+// an option can't be created. abci reserves the codes up to 399
+// for basecoin, governance and ibc, so these start well above them
+const (
+	CodeUnknownOptionType abci.CodeType = 1001 + iota
+	CodeInvalidUnderlying
+	CodeInvalidStrike
+	CodeSameDenom
+	CodeStrikeOverflow
+	CodeExerciseWindow
+	CodeAlreadyExpired
+	CodeExpirationHorizon
+)
+
+// expirations indexes the options by their expiration height
+var expirations = types.NewHeightIndex("expire/")
+
+// auctions indexes the auctioned options by their end height
+var auctions = types.NewHeightIndex("auction/")
+
 // Plugin is a options plugin, storing all state prefixed with it's unique name
 type Plugin struct {
 	name   string
@@ -55,12 +83,18 @@ func (p Plugin) Exec(store bc.KVStore, ctx bc.CallContext, tx types.OptionsTx) a
 		return p.runCreateOption(pstore, accts, ctx, t)
 	case types.SellOptionTx:
 		return p.runSellOption(pstore, accts, ctx, t)
+	case types.CancelSaleTx:
+		return p.runCancelSale(pstore, accts, ctx, t)
 	case types.BuyOptionTx:
 		return p.runBuyOption(pstore, accts, ctx, t)
 	case types.ExerciseOptionTx:
 		return p.runExerciseOption(pstore, accts, ctx, t)
 	case types.DisolveOptionTx:
 		return p.runDisolveOption(pstore, accts, ctx, t)
+	case types.AuctionOptionTx:
+		return p.runAuctionOption(pstore, accts, ctx, t)
+	case types.BidOptionTx:
+		return p.runBidOption(pstore, accts, ctx, t)
 	default:
 		return abci.ErrUnknownRequest
 	}
@@ -75,10 +109,52 @@ func (p *Plugin) BeginBlock(store bc.KVStore, hash []byte, header *abci.Header)
 }
 
 func (p *Plugin) EndBlock(store bc.KVStore, height uint64) abci.ResponseEndBlock {
+	p.settleAuctions(store, height)
+	p.dissolveOptions(store, height)
 	p.height = height + 1
 	return abci.ResponseEndBlock{}
 }
 
+// settleAuctions transfers the options whose auction ended in this block
+// to the highest bidder, paying the bid to the seller.  Without bids, the
+// holder just keeps the option
+func (p Plugin) settleAuctions(store bc.KVStore, height uint64) {
+	accts := trader.NewAccountant(store)
+	pstore := p.prefix(store)
+
+	for _, addr := range auctions.Pop(pstore, height+1, MaxAuctionsPerBlock) {
+		data, err := types.LoadOptionData(pstore, addr)
+		if err != nil || !data.IsAuction() {
+			continue
+		}
+		unindexOption(pstore, data)
+		if data.Auction.HasBid() {
+			accts.Pay(data.Holder, bc.Coins{data.Auction.Bid})
+			data.Holder = data.Auction.Bidder
+		}
+		data.Auction = types.OptionAuction{}
+		indexOption(pstore, data)
+		types.StoreOptionData(pstore, data)
+	}
+}
+
+// dissolveOptions returns the bonds of options that expired before this
+// block to their issuers, just as a DisolveOptionTx would
+func (p Plugin) dissolveOptions(store bc.KVStore, height uint64) {
+	accts := trader.NewAccountant(store)
+	pstore := p.prefix(store)
+
+	for _, addr := range expirations.Pop(pstore, height, MaxDissolvePerBlock) {
+		// skip the ones already exercised or dissolved by a tx
+		data, err := types.LoadOptionData(pstore, addr)
+		if err != nil || !data.IsExpired(height) {
+			continue
+		}
+		accts.Pay(data.Issuer, data.RemainingBond())
+		removeOption(pstore, accts, data)
+	}
+}
+
 func (p *Plugin) assertPlugin() bc.Plugin {
 	return p
 }
@@ -3,6 +3,7 @@ package options
 import (
 	"fmt"
 
+	"github.com/pkg/errors"
 	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/basecoin-examples/trader"
 	"github.com/tendermint/basecoin-examples/trader/types"
@@ -15,28 +16,109 @@ func (p Plugin) runCreateOption(store bc.KVStore,
 	ctx bc.CallContext,
 	tx types.CreateOptionTx) abci.Result {
 
-	issue := types.OptionIssue{
-		Issuer:     ctx.CallerAddress,
-		Serial:     ctx.CallerAccount.Sequence,
-		Expiration: tx.Expiration,
-		Bond:       ctx.Coins,
-		Trade:      tx.Trade,
+	// validate the option, and that the issuer sent the bond
+	err := tx.ValidateBasic()
+	if err == nil {
+		err = tx.CheckHeight(p.height)
 	}
+	var remain bc.Coins
+	if err == nil {
+		remain, err = tx.CheckBond(ctx.Coins)
+	}
+	if err != nil {
+		accts.Refund(ctx)
+		return createError(err)
+	}
+	// anything above the bond is returned
+	accts.Pay(ctx.CallerAddress, remain)
+
+	issue := tx.Issue(ctx.CallerAddress, ctx.CallerAccount.Sequence)
+	issue.Bond, issue.Trade, _ = issue.Terms()
 	data := types.OptionData{
 		OptionIssue: issue,
 		OptionHolder: types.OptionHolder{
 			Holder: ctx.CallerAddress,
 		},
 	}
-	if data.IsExpired(p.height) {
-		accts.Refund(ctx)
-		return abci.ErrEncodingError.AppendLog("Already expired")
-	}
-	types.StoreOptionData(store, data)
+
 	addr := data.Address()
+	types.StoreOptionData(store, data)
+	if data.Expiration != 0 {
+		expirations.Add(store, data.Expiration, addr)
+	}
+	indexOption(store, data)
 	return abci.NewResultOK(addr, fmt.Sprintf("new option: %X", addr))
 }
 
+// createErrors are the codes for each way a CreateOptionTx can be invalid
+var createErrors = map[error]abci.CodeType{
+	types.ErrInsufficientBond:  abci.CodeType_InsufficientFunds,
+	types.ErrUnknownOptionType: CodeUnknownOptionType,
+	types.ErrInvalidUnderlying: CodeInvalidUnderlying,
+	types.ErrInvalidStrike:     CodeInvalidStrike,
+	types.ErrSameDenom:         CodeSameDenom,
+	types.ErrStrikeOverflow:    CodeStrikeOverflow,
+	types.ErrExerciseWindow:    CodeExerciseWindow,
+	types.ErrAlreadyExpired:    CodeAlreadyExpired,
+	types.ErrExpirationHorizon: CodeExpirationHorizon,
+}
+
+// createError maps the errors of CreateOptionTx validation to abci codes
+func createError(err error) abci.Result {
+	code, ok := createErrors[errors.Cause(err)]
+	if !ok {
+		code = abci.CodeType_BaseInvalidInput
+	}
+	return abci.NewError(code, err.Error())
+}
+
+// removeOption deletes the option along with its index entries,
+// returning the bid of an auction that was not settled yet
+func removeOption(store bc.KVStore, accts trader.Accountant, data types.OptionData) {
+	if data.Expiration != 0 {
+		expirations.Remove(store, data.Expiration, data.Address())
+	}
+	if data.IsAuction() {
+		auctions.Remove(store, data.Auction.End, data.Address())
+		if data.Auction.HasBid() {
+			accts.Pay(data.Auction.Bidder, bc.Coins{data.Auction.Bid})
+		}
+	}
+	unindexOption(store, data)
+	types.DeleteOptionData(store, data)
+}
+
+// indexOption adds the option to the holder and for sale indexes
+func indexOption(store bc.KVStore, data types.OptionData) {
+	addr := data.Address()
+	key := types.OptionHolderKey(data.Holder)
+	types.LoadAddrList(store, key).Add(addr).Save(store, key)
+	entry := data.SaleEntry()
+	for _, key := range data.SaleKeys() {
+		types.LoadSaleIndex(store, key).Add(entry).Save(store, key)
+	}
+}
+
+// unindexOption removes the option from the holder and for sale indexes,
+// call it before changing the holder or asking price
+func unindexOption(store bc.KVStore, data types.OptionData) {
+	addr := data.Address()
+	key := types.OptionHolderKey(data.Holder)
+	types.LoadAddrList(store, key).Remove(addr).Save(store, key)
+	for _, key := range data.SaleKeys() {
+		types.LoadSaleIndex(store, key).Remove(addr).Save(store, key)
+	}
+}
+
+// endSale takes the option off the market, keeping the holder
+func endSale(store bc.KVStore, data *types.OptionData) {
+	unindexOption(store, *data)
+	data.NewHolder = nil
+	data.Price = nil
+	data.SaleExpiration = 0
+	indexOption(store, *data)
+}
+
 func (p Plugin) runSellOption(store bc.KVStore,
 	accts trader.Accountant,
 	ctx bc.CallContext,
@@ -55,8 +137,41 @@ func (p Plugin) runSellOption(store bc.KVStore,
 		return abci.ErrUnauthorized.AppendLog("Not option holder")
 	}
 
+	if tx.Expiration != 0 && tx.Expiration < p.height {
+		return abci.ErrBaseInvalidInput.AppendLog("Sale already expired")
+	}
+
+	unindexOption(store, data)
 	data.NewHolder = tx.NewHolder
 	data.Price = tx.Price
+	data.SaleExpiration = tx.Expiration
+	indexOption(store, data)
+	types.StoreOptionData(store, data)
+	return abci.OK
+}
+
+func (p Plugin) runCancelSale(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.CancelSaleTx) abci.Result {
+
+	// always return money sent, no need
+	accts.Refund(ctx)
+
+	data, err := types.LoadOptionData(store, tx.Addr)
+	if err != nil {
+		return abci.ErrEncodingError.AppendLog(err.Error())
+	}
+
+	// make sure we can do this, bids on an auction must be honored
+	if !data.CanSell(ctx.CallerAddress) {
+		return abci.ErrUnauthorized.AppendLog("Not option holder, or auctioned")
+	}
+	if !data.IsForSale() {
+		return abci.ErrBaseInvalidInput.AppendLog("Option is not for sale")
+	}
+
+	endSale(store, &data)
 	types.StoreOptionData(store, data)
 	return abci.OK
 }
@@ -73,7 +188,7 @@ func (p Plugin) runBuyOption(store bc.KVStore,
 	}
 
 	// make sure we can do this
-	if !data.CanBuy(ctx.CallerAddress) {
+	if !data.CanBuy(ctx.CallerAddress, p.height) {
 		accts.Refund(ctx)
 		return abci.ErrUnauthorized.AppendLog("Can't buy this option")
 	}
@@ -88,9 +203,12 @@ func (p Plugin) runBuyOption(store bc.KVStore,
 	// send the money to the seller
 	accts.Pay(data.Holder, data.Price)
 	// transfer ownership
+	unindexOption(store, data)
 	data.Holder = ctx.CallerAddress
 	data.NewHolder = nil
 	data.Price = nil
+	data.SaleExpiration = 0
+	indexOption(store, data)
 	types.StoreOptionData(store, data)
 	// and refund any overpayment
 	accts.Pay(ctx.CallerAddress, remain)
@@ -112,28 +230,141 @@ func (p Plugin) runExerciseOption(store bc.KVStore,
 	// make sure we can do this
 	if !data.CanExercise(ctx.CallerAddress, p.height) {
 		accts.Refund(ctx)
+		if !data.InExerciseWindow(p.height) {
+			return abci.ErrUnauthorized.AppendLog(
+				fmt.Sprintf("Can only exercise between heights %d and %d",
+					data.ExerciseStart, data.ExerciseEnd))
+		}
 		return abci.ErrUnauthorized.AppendLog("Can't exercise this option")
 	}
 
-	// make sure there is enough money to trade
-	remain := ctx.Coins.Minus(data.Trade)
+	units := tx.Units
+	if units == 0 {
+		units = data.Remaining()
+	}
+	if units < 0 || units > data.Remaining() {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog(
+			fmt.Sprintf("Can exercise up to %d units", data.Remaining()))
+	}
+
+	// the holder of a call pays the strike value for the underlying,
+	// the holder of a put delivers the underlying for the strike value
+	pays, receives := data.ExerciseTerms(units)
+	remain := ctx.Coins.Minus(pays)
 	if !remain.IsNonnegative() {
 		accts.Refund(ctx)
-		return abci.ErrInsufficientFunds.AppendLog("Option requires higher trade value")
+		return abci.ErrInsufficientFunds.AppendLog(
+			fmt.Sprintf("Exercising this %s option requires %s", data.Type, pays))
 	}
 
-	// pay back caller over-payment and the bond value
+	// pay back caller over-payment and what the bond held
 	accts.Pay(ctx.CallerAddress, remain)
-	accts.Pay(ctx.CallerAddress, data.Bond)
-	// the trade value goes to the original issuer
-	accts.Pay(data.Issuer, data.Trade)
+	accts.Pay(ctx.CallerAddress, receives)
+	// what the holder paid goes to the original issuer
+	accts.Pay(data.Issuer, pays)
 
-	// and remove this option from history
-	types.DeleteOptionData(store, data)
+	// keep the rest for later, or remove this option from history.
+	// the price was asked for more units, so any offer is withdrawn
+	data.Exercised += units
+	if data.Remaining() > 0 {
+		if data.IsForSale() {
+			endSale(store, &data)
+		}
+		types.StoreOptionData(store, data)
+	} else {
+		removeOption(store, accts, data)
+	}
 
 	return abci.OK
 }
 
+func (p Plugin) runAuctionOption(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.AuctionOptionTx) abci.Result {
+
+	// always return money sent, no need
+	accts.Refund(ctx)
+
+	data, err := types.LoadOptionData(store, tx.Addr)
+	if err != nil {
+		return abci.ErrEncodingError.AppendLog(err.Error())
+	}
+
+	// make sure we can do this
+	if !data.CanSell(ctx.CallerAddress) {
+		return abci.ErrUnauthorized.AppendLog("Not option holder, or already auctioned")
+	}
+	if tx.Reserve.Denom == "" || tx.Reserve.Amount <= 0 {
+		return abci.ErrBaseInvalidInput.AppendLog("Reserve must be positive")
+	}
+	// the auction must settle while the option can still be used
+	if tx.End < p.height {
+		return abci.ErrBaseInvalidInput.AppendLog("Auction already ended")
+	}
+	if data.Expiration != 0 && tx.End > data.Expiration {
+		return abci.ErrBaseInvalidInput.AppendLog("Auction must end before expiration")
+	}
+
+	// an auction replaces any fixed price offer
+	unindexOption(store, data)
+	data.NewHolder = nil
+	data.Price = nil
+	data.SaleExpiration = 0
+	data.Auction = types.OptionAuction{
+		Reserve: tx.Reserve,
+		End:     tx.End,
+	}
+	indexOption(store, data)
+	auctions.Add(store, tx.End, tx.Addr)
+	types.StoreOptionData(store, data)
+	return abci.OK
+}
+
+func (p Plugin) runBidOption(store bc.KVStore,
+	accts trader.Accountant,
+	ctx bc.CallContext,
+	tx types.BidOptionTx) abci.Result {
+
+	data, err := types.LoadOptionData(store, tx.Addr)
+	if err != nil {
+		accts.Refund(ctx)
+		return abci.ErrEncodingError.AppendLog(err.Error())
+	}
+
+	// make sure we can do this
+	if !data.CanBid(ctx.CallerAddress, p.height) {
+		accts.Refund(ctx)
+		return abci.ErrUnauthorized.AppendLog("Can't bid on this option")
+	}
+
+	// the bid is all coins sent, in the denomination of the reserve
+	min := data.Auction.MinBid()
+	if len(ctx.Coins) != 1 || ctx.Coins[0].Denom != min.Denom {
+		accts.Refund(ctx)
+		return abci.ErrBaseInvalidInput.AppendLog(
+			fmt.Sprintf("Must bid in %s only", min.Denom))
+	}
+	if ctx.Coins[0].Amount < min.Amount {
+		accts.Refund(ctx)
+		return abci.ErrInsufficientFunds.AppendLog(
+			fmt.Sprintf("Must bid at least %s", min))
+	}
+
+	// return the locked coins to the outbid bidder,
+	// the higher bid raises the asking price in the sale indexes
+	if data.Auction.HasBid() {
+		accts.Pay(data.Auction.Bidder, bc.Coins{data.Auction.Bid})
+	}
+	unindexOption(store, data)
+	data.Auction.Bidder = ctx.CallerAddress
+	data.Auction.Bid = ctx.Coins[0]
+	indexOption(store, data)
+	types.StoreOptionData(store, data)
+	return abci.OK
+}
+
 func (p Plugin) runDisolveOption(store bc.KVStore,
 	accts trader.Accountant,
 	ctx bc.CallContext,
@@ -152,10 +383,10 @@ func (p Plugin) runDisolveOption(store bc.KVStore,
 		return abci.ErrUnauthorized.AppendLog("Can't exercise this option")
 	}
 
-	// return bond to the issue
-	accts.Pay(data.Issuer, data.Bond)
+	// return what is left of the bond to the issuer
+	accts.Pay(data.Issuer, data.RemainingBond())
 	// and remove this option from history
-	types.DeleteOptionData(store, data)
+	removeOption(store, accts, data)
 
 	return abci.OK
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/basecoin-examples/trader"
 	"github.com/tendermint/basecoin-examples/trader/types"
 	bc "github.com/tendermint/basecoin/types"
@@ -23,13 +24,15 @@ func TestBasicFlow(t *testing.T) {
 
 	a, b, c := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
 	bond := bc.Coins{{Amount: 1000, Denom: "ATOM"}}
-	trade := bc.Coins{{Amount: 5, Denom: "BTC"}}
+	trade := bc.Coins{{Amount: 5000, Denom: "BTC"}}
 	price := bc.Coins{{Amount: 10, Denom: "ETH"}}
 	low := bc.Coins{{Amount: 8, Denom: "ETH"}}
 
 	tx := types.CreateOptionTx{
+		Type:       types.OptionCall,
+		Underlying: bond[0],
+		Strike:     bc.Coin{Amount: 5, Denom: "BTC"},
 		Expiration: 100,
-		Trade:      trade,
 	}
 	ctx := bc.CallContext{
 		CallerAddress: a,
@@ -53,6 +56,7 @@ func TestBasicFlow(t *testing.T) {
 	assert.Nil(err)
 	assert.EqualValues(addr, data.Address())
 	assert.Equal(bond, data.Bond)
+	assert.Equal(trade, data.Trade)
 	assert.Equal(20, data.Serial)
 	assert.Equal(a, data.Issuer)
 	assert.Equal(a, data.Holder)
@@ -141,3 +145,687 @@ func TestBasicFlow(t *testing.T) {
 	assert.False(ab.Balance.IsZero())
 	assert.True(ab.Balance.IsGTE(bond)) // b got the bond
 }
+
+func TestPutOption(t *testing.T) {
+	assert := assert.New(t)
+
+	store := bc.NewMemKVStore()
+	plugin := Plugin{
+		height: 50,
+		name:   "options",
+	}
+	pstore := plugin.prefix(store)
+	accts := trader.NewAccountant(store)
+
+	a, b := cmn.RandBytes(20), cmn.RandBytes(20)
+	eth := bc.Coin{Amount: 100, Denom: "ETH"}
+	strike := bc.Coin{Amount: 2, Denom: "BTC"}
+	value := bc.Coins{{Amount: 200, Denom: "BTC"}}
+
+	// the issuer of a put must bond the strike value
+	tx := types.CreateOptionTx{
+		Type:       types.OptionPut,
+		Underlying: eth,
+		Strike:     strike,
+		Expiration: 100,
+	}
+	ctx := bc.CallContext{
+		CallerAddress: a,
+		Coins:         bc.Coins{{Amount: 150, Denom: "BTC"}},
+		CallerAccount: &bc.Account{Sequence: 1},
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.Equal(abci.CodeType_InsufficientFunds, res.Code, res.Log)
+	assert.Equal(bc.Coins{{Amount: 150, Denom: "BTC"}}, accts.GetAccount(a).Balance)
+
+	// any overpayment is returned
+	ctx.Coins = bc.Coins{{Amount: 250, Denom: "BTC"}}
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+	data, err := types.LoadOptionData(pstore, addr)
+	assert.Nil(err)
+	assert.Equal(value, data.Bond)
+	assert.Equal(bc.Coins{eth}, data.Trade)
+	assert.Equal(bc.Coins{{Amount: 200, Denom: "BTC"}}, accts.GetAccount(a).Balance)
+
+	// give it to b
+	sell := types.SellOptionTx{Addr: addr, Price: bc.Coins{{Amount: 1, Denom: "mycoin"}}}
+	ctx.Coins = nil
+	res = plugin.Exec(store, ctx, sell)
+	assert.True(res.IsOK(), res.Log)
+	ctxb := bc.CallContext{
+		CallerAddress: b,
+		Coins:         bc.Coins{{Amount: 1, Denom: "mycoin"}},
+	}
+	res = plugin.Exec(store, ctxb, types.BuyOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+
+	// b must deliver the underlying, not the strike value
+	ctxb.Coins = value
+	res = plugin.Exec(store, ctxb, types.ExerciseOptionTx{Addr: addr})
+	assert.Equal(abci.CodeType_InsufficientFunds, res.Code, res.Log)
+	ctxb.Coins = bc.Coins{eth}
+	res = plugin.Exec(store, ctxb, types.ExerciseOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+
+	// b sold the eth at the strike price to a
+	ab := accts.GetAccount(b)
+	assert.True(ab.Balance.IsGTE(value))
+	assert.False(ab.Balance.IsGTE(bc.Coins{eth}))
+	aa := accts.GetAccount(a)
+	assert.True(aa.Balance.IsGTE(bc.Coins{eth}))
+	_, err = types.LoadOptionData(pstore, addr)
+	assert.NotNil(err)
+}
+
+func TestPartialExercise(t *testing.T) {
+	assert := assert.New(t)
+
+	store := bc.NewMemKVStore()
+	plugin := Plugin{
+		height: 50,
+		name:   "options",
+	}
+	pstore := plugin.prefix(store)
+	accts := trader.NewAccountant(store)
+
+	a := cmn.RandBytes(20)
+	eth := bc.Coins{{Amount: 100, Denom: "ETH"}}
+
+	// a call on 100 ETH at 2 BTC each, held by the issuer
+	tx := types.CreateOptionTx{
+		Type:       types.OptionCall,
+		Underlying: eth[0],
+		Strike:     bc.Coin{Amount: 2, Denom: "BTC"},
+		Expiration: 100,
+	}
+	ctx := bc.CallContext{
+		CallerAddress: a,
+		Coins:         eth,
+		CallerAccount: &bc.Account{Sequence: 1},
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// can't exercise more than there is
+	ctx.Coins = bc.Coins{{Amount: 500, Denom: "BTC"}}
+	res = plugin.Exec(store, ctx, types.ExerciseOptionTx{Addr: addr, Units: 101})
+	assert.Equal(abci.CodeType_BaseInvalidInput, res.Code, res.Log)
+	res = plugin.Exec(store, ctx, types.ExerciseOptionTx{Addr: addr, Units: -1})
+	assert.True(res.IsErr())
+
+	// exercise 30 units, the option stays with the rest
+	ctx.Coins = bc.Coins{{Amount: 60, Denom: "BTC"}}
+	res = plugin.Exec(store, ctx, types.ExerciseOptionTx{Addr: addr, Units: 30})
+	assert.True(res.IsOK(), res.Log)
+	data, err := types.LoadOptionData(pstore, addr)
+	assert.Nil(err)
+	assert.Equal(int64(30), data.Exercised)
+	assert.Equal(int64(70), data.Remaining())
+	// (the issuer holds it, so gets back the 60 BTC, plus the failed refunds)
+	assert.Equal(bc.Coins{
+		{Amount: 1060, Denom: "BTC"},
+		{Amount: 30, Denom: "ETH"},
+	}, accts.GetAccount(a).Balance)
+
+	// dissolving only returns the remaining bond
+	res = plugin.Exec(store, ctx, types.DisolveOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+	aa := accts.GetAccount(a)
+	assert.True(aa.Balance.IsGTE(eth))
+	assert.False(aa.Balance.IsGTE(bc.Coins{{Amount: 101, Denom: "ETH"}}))
+	_, err = types.LoadOptionData(pstore, addr)
+	assert.NotNil(err)
+
+	// a new option is exercised in full with Units 0
+	ctx.Coins = eth
+	ctx.CallerAccount = &bc.Account{Sequence: 2}
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	ctx.Coins = bc.Coins{{Amount: 60, Denom: "BTC"}}
+	res = plugin.Exec(store, ctx, types.ExerciseOptionTx{Addr: addr, Units: 30})
+	assert.True(res.IsOK(), res.Log)
+	ctx.Coins = bc.Coins{{Amount: 139, Denom: "BTC"}}
+	res = plugin.Exec(store, ctx, types.ExerciseOptionTx{Addr: addr})
+	assert.Equal(abci.CodeType_InsufficientFunds, res.Code, res.Log)
+	ctx.Coins = bc.Coins{{Amount: 140, Denom: "BTC"}}
+	res = plugin.Exec(store, ctx, types.ExerciseOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+	_, err = types.LoadOptionData(pstore, addr)
+	assert.NotNil(err)
+
+	// exercising part of an option on sale withdraws the offer,
+	// so a buyer can't pay the full price for what is left
+	b := cmn.RandBytes(20)
+	ctx.Coins = eth
+	ctx.CallerAccount = &bc.Account{Sequence: 3}
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr = res.Data
+	price := bc.Coins{{Amount: 50, Denom: "BTC"}}
+	res = plugin.Exec(store, ctx, types.SellOptionTx{Addr: addr, Price: price})
+	assert.True(res.IsOK(), res.Log)
+	ctx.Coins = bc.Coins{{Amount: 198, Denom: "BTC"}}
+	res = plugin.Exec(store, ctx, types.ExerciseOptionTx{Addr: addr, Units: 99})
+	assert.True(res.IsOK(), res.Log)
+	data, err = types.LoadOptionData(pstore, addr)
+	if assert.Nil(err) {
+		assert.False(data.IsForSale())
+		assert.Equal(int64(1), data.Remaining())
+	}
+	assert.False(types.LoadSaleIndex(pstore, types.OptionsForSaleKey).Has(addr))
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: b, Coins: price}, types.BuyOptionTx{Addr: addr})
+	assert.Equal(abci.CodeType_Unauthorized, res.Code, res.Log)
+	assert.Equal(price, accts.GetAccount(b).Balance)
+}
+
+func TestEuropeanOption(t *testing.T) {
+	assert := assert.New(t)
+
+	store := bc.NewMemKVStore()
+	plugin := Plugin{
+		height: 50,
+		name:   "options",
+	}
+	pstore := plugin.prefix(store)
+
+	a := cmn.RandBytes(20)
+	eth := bc.Coins{{Amount: 10, Denom: "ETH"}}
+	btc := bc.Coins{{Amount: 20, Denom: "BTC"}}
+
+	tx := types.CreateOptionTx{
+		Type:          types.OptionCall,
+		Underlying:    eth[0],
+		Strike:        bc.Coin{Amount: 2, Denom: "BTC"},
+		Expiration:    100,
+		ExerciseStart: 100,
+		ExerciseEnd:   120,
+	}
+	ctx := bc.CallContext{
+		CallerAddress: a,
+		Coins:         eth,
+		CallerAccount: &bc.Account{Sequence: 1},
+	}
+	// the window must close before expiration
+	res := plugin.Exec(store, ctx, tx)
+	assert.Equal(CodeExerciseWindow, res.Code, res.Log)
+	tx.ExerciseEnd = 100
+	res = plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+	data, err := types.LoadOptionData(pstore, addr)
+	assert.Nil(err)
+	assert.Equal(uint64(100), data.ExerciseStart)
+
+	// not before maturity
+	ctx.Coins = btc
+	ex := types.ExerciseOptionTx{Addr: addr}
+	res = plugin.Exec(store, ctx, ex)
+	assert.Equal(abci.CodeType_Unauthorized, res.Code, res.Log)
+
+	// but at maturity
+	plugin.height = 100
+	res = plugin.Exec(store, ctx, ex)
+	assert.True(res.IsOK(), res.Log)
+	_, err = types.LoadOptionData(pstore, addr)
+	assert.NotNil(err)
+}
+
+func TestEndBlockDissolve(t *testing.T) {
+	assert := assert.New(t)
+	store := bc.NewMemKVStore()
+	issuer := cmn.RandBytes(20)
+	eth := bc.Coins{{Amount: 10, Denom: "ETH"}}
+
+	plugin := Plugin{
+		height: 100,
+		name:   "options",
+	}
+	pstore := plugin.prefix(store)
+	accts := trader.NewAccountant(store)
+
+	// more options than dissolve in one block, one partly exercised
+	// and one that expires much later
+	var addrs [][]byte
+	for i := 0; i < MaxDissolvePerBlock+3; i++ {
+		ctx := bc.CallContext{
+			CallerAddress: issuer,
+			Coins:         eth,
+			CallerAccount: &bc.Account{
+				Sequence: i + 1,
+			},
+		}
+		tx := types.CreateOptionTx{
+			Type:       types.OptionCall,
+			Underlying: eth[0],
+			Strike:     bc.Coin{Amount: 2, Denom: "BTC"},
+			Expiration: uint64(200 + i%2),
+		}
+		if i == MaxDissolvePerBlock+2 {
+			tx.Expiration = 5000
+		}
+		res := plugin.Exec(store, ctx, tx)
+		assert.True(res.IsOK(), res.Log)
+		addrs = append(addrs, res.Data)
+	}
+	ctx := bc.CallContext{
+		CallerAddress: issuer,
+		Coins:         bc.Coins{{Amount: 8, Denom: "BTC"}},
+	}
+	res := plugin.Exec(store, ctx, types.ExerciseOptionTx{Addr: addrs[0], Units: 4})
+	assert.True(res.IsOK(), res.Log)
+	ethBalance := func() int64 {
+		return accts.GetAccount(issuer).Balance.Minus(ctx.Coins)[0].Amount
+	}
+	assert.Equal(int64(4), ethBalance())
+
+	// nothing happens before the expiration
+	plugin.EndBlock(store, 200)
+	assert.Equal(int64(4), ethBalance())
+	assert.Equal(uint64(201), plugin.height)
+
+	// then at most MaxDissolvePerBlock are returned per block,
+	// the exercised one only with what is left of the bond
+	plugin.EndBlock(store, 202)
+	assert.Equal(int64(10*MaxDissolvePerBlock), ethBalance())
+	_, err := types.LoadOptionData(pstore, addrs[0])
+	assert.NotNil(err)
+	_, err = types.LoadOptionData(pstore, addrs[MaxDissolvePerBlock+1])
+	assert.Nil(err)
+
+	// and the rest in the next one
+	plugin.EndBlock(store, 203)
+	assert.Equal(int64(10*(MaxDissolvePerBlock+2)), ethBalance())
+	_, err = types.LoadOptionData(pstore, addrs[MaxDissolvePerBlock+1])
+	assert.NotNil(err)
+
+	// the later one stays until its expiration
+	plugin.EndBlock(store, 5000)
+	_, err = types.LoadOptionData(pstore, addrs[MaxDissolvePerBlock+2])
+	assert.Nil(err)
+}
+
+func TestMarketplaceIndexes(t *testing.T) {
+	assert := assert.New(t)
+
+	store := bc.NewMemKVStore()
+	plugin := Plugin{
+		height: 50,
+		name:   "options",
+	}
+	pstore := plugin.prefix(store)
+
+	a, b := cmn.RandBytes(20), cmn.RandBytes(20)
+	eth := bc.Coins{{Amount: 10, Denom: "ETH"}}
+	price := bc.Coins{{Amount: 3, Denom: "mycoin"}}
+	forSale := func(addr []byte) bool {
+		return types.LoadSaleIndex(pstore, types.OptionsForSaleKey).Has(addr)
+	}
+	holds := func(holder, addr []byte) bool {
+		return types.LoadAddrList(pstore, types.OptionHolderKey(holder)).Has(addr)
+	}
+
+	// the issuer holds the new option
+	tx := types.CreateOptionTx{
+		Type:       types.OptionCall,
+		Underlying: eth[0],
+		Strike:     bc.Coin{Amount: 2, Denom: "BTC"},
+		Expiration: 100,
+	}
+	ctx := bc.CallContext{
+		CallerAddress: a,
+		Coins:         eth,
+		CallerAccount: &bc.Account{Sequence: 1},
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+	assert.True(holds(a, addr))
+	assert.False(forSale(addr))
+
+	// offered for sale, and withdrawn with a zero price
+	ctx.Coins = nil
+	res = plugin.Exec(store, ctx, types.SellOptionTx{Addr: addr, Price: price})
+	assert.True(res.IsOK(), res.Log)
+	assert.True(forSale(addr))
+	// also under its pair of denoms, with the asking price
+	pair := types.LoadSaleIndex(pstore, types.OptionsForSalePairKey("ETH", "BTC"))
+	if assert.Equal(1, len(pair)) {
+		assert.Equal(addr, pair[0].Address)
+		assert.Equal(price, pair[0].Price)
+		assert.Equal(uint64(100), pair[0].Expiration)
+	}
+	res = plugin.Exec(store, ctx, types.SellOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+	assert.False(forSale(addr))
+	res = plugin.Exec(store, ctx, types.SellOptionTx{Addr: addr, Price: price})
+	assert.True(res.IsOK(), res.Log)
+
+	// once bought it moves to the new holder's portfolio
+	ctxb := bc.CallContext{CallerAddress: b, Coins: price}
+	res = plugin.Exec(store, ctxb, types.BuyOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+	assert.False(forSale(addr))
+	assert.False(holds(a, addr))
+	assert.True(holds(b, addr))
+
+	// and leaves all indexes when it is gone
+	ctxb.Coins = bc.Coins{{Amount: 20, Denom: "BTC"}}
+	res = plugin.Exec(store, ctxb, types.ExerciseOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+	assert.False(holds(b, addr))
+	assert.Empty(pstore.Get(types.OptionHolderKey(b)))
+	assert.Empty(pstore.Get(types.OptionsForSalePairKey("ETH", "BTC")))
+}
+
+func TestAuction(t *testing.T) {
+	assert := assert.New(t)
+
+	store := bc.NewMemKVStore()
+	plugin := Plugin{
+		height: 50,
+		name:   "options",
+	}
+	pstore := plugin.prefix(store)
+	accts := trader.NewAccountant(store)
+
+	a, b, c := cmn.RandBytes(20), cmn.RandBytes(20), cmn.RandBytes(20)
+	eth := bc.Coins{{Amount: 10, Denom: "ETH"}}
+	coins := func(amount int64) bc.Coins {
+		return bc.Coins{{Amount: amount, Denom: "mycoin"}}
+	}
+
+	tx := types.CreateOptionTx{
+		Type:       types.OptionCall,
+		Underlying: eth[0],
+		Strike:     bc.Coin{Amount: 2, Denom: "BTC"},
+		Expiration: 100,
+	}
+	ctx := bc.CallContext{
+		CallerAddress: a,
+		Coins:         eth,
+		CallerAccount: &bc.Account{Sequence: 1},
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	// only the holder may auction it, ending before expiration
+	ctx.Coins = nil
+	auction := types.AuctionOptionTx{
+		Addr:    addr,
+		Reserve: bc.Coin{Amount: 10, Denom: "mycoin"},
+		End:     101,
+	}
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: b}, auction)
+	assert.Equal(abci.CodeType_Unauthorized, res.Code, res.Log)
+	res = plugin.Exec(store, ctx, auction)
+	assert.Equal(abci.CodeType_BaseInvalidInput, res.Code, res.Log)
+	auction.End = 60
+	res = plugin.Exec(store, ctx, auction)
+	assert.True(res.IsOK(), res.Log)
+	assert.True(types.LoadSaleIndex(pstore, types.OptionsForSaleKey).Has(addr))
+
+	// nor can the holder exercise it or start another auction now
+	res = plugin.Exec(store, ctx, auction)
+	assert.True(res.IsErr())
+	res = plugin.Exec(store, ctx, types.ExerciseOptionTx{Addr: addr})
+	assert.True(res.IsErr())
+
+	// bids must reach the reserve, in the right coin
+	ctxb := bc.CallContext{CallerAddress: b, Coins: coins(9)}
+	res = plugin.Exec(store, ctxb, types.BidOptionTx{Addr: addr})
+	assert.Equal(abci.CodeType_InsufficientFunds, res.Code, res.Log)
+	ctxb.Coins = bc.Coins{{Amount: 20, Denom: "BTC"}}
+	res = plugin.Exec(store, ctxb, types.BidOptionTx{Addr: addr})
+	assert.Equal(abci.CodeType_BaseInvalidInput, res.Code, res.Log)
+	ctxb.Coins = coins(10)
+	res = plugin.Exec(store, ctxb, types.BidOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+	// the refunds from the failed bids stay with b, the bid is locked
+	assert.Equal(bc.Coins{{Amount: 20, Denom: "BTC"}, {Amount: 9, Denom: "mycoin"}},
+		accts.GetAccount(b).Balance)
+
+	// c must outbid b, who gets the bid back
+	ctxc := bc.CallContext{CallerAddress: c, Coins: coins(10)}
+	res = plugin.Exec(store, ctxc, types.BidOptionTx{Addr: addr})
+	assert.Equal(abci.CodeType_InsufficientFunds, res.Code, res.Log)
+	ctxc.Coins = coins(15)
+	res = plugin.Exec(store, ctxc, types.BidOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+	assert.Equal(bc.Coins{{Amount: 20, Denom: "BTC"}, {Amount: 19, Denom: "mycoin"}},
+		accts.GetAccount(b).Balance)
+	// the sale index asks for more than the highest bid
+	idx := types.LoadSaleIndex(pstore, types.OptionsForSaleKey)
+	if assert.Equal(1, len(idx)) {
+		assert.Equal(coins(16), idx[0].Price)
+	}
+
+	// nothing before the end, bids are still taken at the end height
+	plugin.EndBlock(store, 59)
+	data, err := types.LoadOptionData(pstore, addr)
+	assert.Nil(err)
+	assert.True(data.IsAuction())
+	assert.Equal(uint64(60), plugin.height)
+	ctxb.Coins = coins(16)
+	res = plugin.Exec(store, ctxb, types.BidOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+
+	// and then it settles with the highest bidder
+	plugin.EndBlock(store, 60)
+	data, err = types.LoadOptionData(pstore, addr)
+	assert.Nil(err)
+	assert.False(data.IsAuction())
+	assert.Equal(b, data.Holder)
+	assert.Equal(coins(16), accts.GetAccount(a).Balance)
+	// c got back the failed and the outbid one
+	assert.Equal(coins(25), accts.GetAccount(c).Balance)
+	assert.False(types.LoadSaleIndex(pstore, types.OptionsForSaleKey).Has(addr))
+	assert.True(types.LoadAddrList(pstore, types.OptionHolderKey(b)).Has(addr))
+
+	// too late to bid
+	res = plugin.Exec(store, ctxc, types.BidOptionTx{Addr: addr})
+	assert.Equal(abci.CodeType_Unauthorized, res.Code, res.Log)
+
+	// an auction without bids leaves the option with the holder
+	auction.End = 70
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: b}, auction)
+	assert.True(res.IsOK(), res.Log)
+	plugin.EndBlock(store, 70)
+	data, err = types.LoadOptionData(pstore, addr)
+	assert.Nil(err)
+	assert.False(data.IsAuction())
+	assert.Equal(b, data.Holder)
+}
+
+func TestCancelSale(t *testing.T) {
+	assert := assert.New(t)
+
+	store := bc.NewMemKVStore()
+	plugin := Plugin{
+		height: 50,
+		name:   "options",
+	}
+	pstore := plugin.prefix(store)
+
+	a, b := cmn.RandBytes(20), cmn.RandBytes(20)
+	eth := bc.Coins{{Amount: 10, Denom: "ETH"}}
+	price := bc.Coins{{Amount: 3, Denom: "mycoin"}}
+
+	tx := types.CreateOptionTx{
+		Type:       types.OptionCall,
+		Underlying: eth[0],
+		Strike:     bc.Coin{Amount: 2, Denom: "BTC"},
+		Expiration: 100,
+	}
+	ctx := bc.CallContext{
+		CallerAddress: a,
+		Coins:         eth,
+		CallerAccount: &bc.Account{Sequence: 1},
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+	ctx.Coins = nil
+	ctxb := bc.CallContext{CallerAddress: b, Coins: price}
+
+	// nothing to cancel yet
+	cancel := types.CancelSaleTx{Addr: addr}
+	res = plugin.Exec(store, ctx, cancel)
+	assert.Equal(abci.CodeType_BaseInvalidInput, res.Code, res.Log)
+
+	// an offer can't expire in the past
+	sell := types.SellOptionTx{Addr: addr, Price: price, Expiration: 40}
+	res = plugin.Exec(store, ctx, sell)
+	assert.Equal(abci.CodeType_BaseInvalidInput, res.Code, res.Log)
+
+	// only the holder cancels, and then no one can buy
+	sell.Expiration = 60
+	res = plugin.Exec(store, ctx, sell)
+	assert.True(res.IsOK(), res.Log)
+	res = plugin.Exec(store, ctxb, cancel)
+	assert.Equal(abci.CodeType_Unauthorized, res.Code, res.Log)
+	res = plugin.Exec(store, ctx, cancel)
+	assert.True(res.IsOK(), res.Log)
+	data, err := types.LoadOptionData(pstore, addr)
+	assert.Nil(err)
+	assert.False(data.IsForSale())
+	assert.Equal(uint64(0), data.SaleExpiration)
+	assert.False(types.LoadSaleIndex(pstore, types.OptionsForSaleKey).Has(addr))
+	res = plugin.Exec(store, ctxb, types.BuyOptionTx{Addr: addr})
+	assert.Equal(abci.CodeType_Unauthorized, res.Code, res.Log)
+
+	// an expired offer can't be bought either
+	res = plugin.Exec(store, ctx, sell)
+	assert.True(res.IsOK(), res.Log)
+	plugin.height = 61
+	res = plugin.Exec(store, ctxb, types.BuyOptionTx{Addr: addr})
+	assert.Equal(abci.CodeType_Unauthorized, res.Code, res.Log)
+	plugin.height = 60
+	res = plugin.Exec(store, ctxb, types.BuyOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+	data, err = types.LoadOptionData(pstore, addr)
+	assert.Nil(err)
+	assert.Equal(b, data.Holder)
+	assert.Equal(uint64(0), data.SaleExpiration)
+}
+
+func TestCreateValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	store := bc.NewMemKVStore()
+	plugin := Plugin{
+		height: 50,
+		name:   "options",
+	}
+	accts := trader.NewAccountant(store)
+
+	a := cmn.RandBytes(20)
+	eth := bc.Coin{Amount: 10, Denom: "ETH"}
+	strike := bc.Coin{Amount: 2, Denom: "BTC"}
+
+	cases := []struct {
+		tx    types.CreateOptionTx
+		coins bc.Coins
+		code  abci.CodeType
+	}{
+		// bad coins
+		{types.CreateOptionTx{Strike: strike, Expiration: 100}, bc.Coins{eth}, CodeInvalidUnderlying},
+		{types.CreateOptionTx{Underlying: eth, Expiration: 100}, bc.Coins{eth}, CodeInvalidStrike},
+		{types.CreateOptionTx{Underlying: eth, Strike: bc.Coin{Amount: 2, Denom: "ETH"}, Expiration: 100},
+			bc.Coins{eth}, CodeSameDenom},
+		{types.CreateOptionTx{Type: types.OptionType(5), Underlying: eth, Strike: strike, Expiration: 100},
+			bc.Coins{eth}, CodeUnknownOptionType},
+		{types.CreateOptionTx{Underlying: bc.Coin{Amount: 1 << 40, Denom: "ETH"},
+			Strike: bc.Coin{Amount: 1 << 40, Denom: "BTC"}, Expiration: 100},
+			bc.Coins{eth}, CodeStrikeOverflow},
+		// bad heights
+		{types.CreateOptionTx{Underlying: eth, Strike: strike, Expiration: 100, ExerciseStart: 101},
+			bc.Coins{eth}, CodeExerciseWindow},
+		{types.CreateOptionTx{Underlying: eth, Strike: strike, Expiration: 40},
+			bc.Coins{eth}, CodeAlreadyExpired},
+		{types.CreateOptionTx{Underlying: eth, Strike: strike, Expiration: 51 + types.MaxExpirationHorizon},
+			bc.Coins{eth}, CodeExpirationHorizon},
+		{types.CreateOptionTx{Underlying: eth, Strike: strike},
+			bc.Coins{eth}, CodeExpirationHorizon},
+		// no bond, or not enough
+		{types.CreateOptionTx{Underlying: eth, Strike: strike, Expiration: 100},
+			nil, abci.CodeType_InsufficientFunds},
+		{types.CreateOptionTx{Underlying: eth, Strike: strike, Expiration: 100},
+			bc.Coins{{Amount: 9, Denom: "ETH"}}, abci.CodeType_InsufficientFunds},
+		// and finally a good one
+		{types.CreateOptionTx{Underlying: eth, Strike: strike, Expiration: 50 + types.MaxExpirationHorizon},
+			bc.Coins{eth}, abci.CodeType_OK},
+	}
+
+	for i, tc := range cases {
+		ctx := bc.CallContext{
+			CallerAddress: a,
+			Coins:         tc.coins,
+			CallerAccount: &bc.Account{Sequence: i + 1},
+		}
+		res := plugin.Exec(store, ctx, tc.tx)
+		assert.Equal(tc.code, res.Code, "%d: %s", i, res.Log)
+	}
+
+	// all but the bond of the last one was refunded
+	total := bc.Coins{{Amount: 9*10 + 9, Denom: "ETH"}}
+	assert.Equal(total, accts.GetAccount(a).Balance)
+}
+
+func TestDissolveUnsettledAuction(t *testing.T) {
+	assert := assert.New(t)
+
+	store := bc.NewMemKVStore()
+	plugin := Plugin{
+		height: 50,
+		name:   "options",
+	}
+	pstore := plugin.prefix(store)
+	accts := trader.NewAccountant(store)
+
+	a, b := cmn.RandBytes(20), cmn.RandBytes(20)
+	eth := bc.Coins{{Amount: 10, Denom: "ETH"}}
+	bid := bc.Coins{{Amount: 12, Denom: "mycoin"}}
+
+	tx := types.CreateOptionTx{
+		Type:       types.OptionCall,
+		Underlying: eth[0],
+		Strike:     bc.Coin{Amount: 2, Denom: "BTC"},
+		Expiration: 100,
+	}
+	ctx := bc.CallContext{
+		CallerAddress: a,
+		Coins:         eth,
+		CallerAccount: &bc.Account{Sequence: 1},
+	}
+	res := plugin.Exec(store, ctx, tx)
+	assert.True(res.IsOK(), res.Log)
+	addr := res.Data
+
+	ctx.Coins = nil
+	auction := types.AuctionOptionTx{
+		Addr:    addr,
+		Reserve: bc.Coin{Amount: 10, Denom: "mycoin"},
+		End:     100,
+	}
+	res = plugin.Exec(store, ctx, auction)
+	assert.True(res.IsOK(), res.Log)
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: b, Coins: bid}, types.BidOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+
+	// the auction was never settled (eg. a backlog), and the option expired
+	plugin.height = 101
+	res = plugin.Exec(store, bc.CallContext{CallerAddress: b}, types.DisolveOptionTx{Addr: addr})
+	assert.True(res.IsOK(), res.Log)
+	_, err := types.LoadOptionData(pstore, addr)
+	assert.NotNil(err)
+
+	// the bidder got the bid back, and the issuer the bond
+	assert.Equal(bid, accts.GetAccount(b).Balance)
+	assert.Equal(eth, accts.GetAccount(a).Balance)
+	assert.False(types.LoadSaleIndex(pstore, types.OptionsForSaleKey).Has(addr))
+}
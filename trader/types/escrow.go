@@ -1,7 +1,10 @@
 package types
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"math/big"
 
 	"github.com/tendermint/basecoin/types"
 	wire "github.com/tendermint/go-wire"
@@ -15,6 +18,17 @@ func init() {
 		wire.ConcreteType{O: CreateEscrowTx{}, Byte: 0x01},
 		wire.ConcreteType{O: ResolveEscrowTx{}, Byte: 0x02},
 		wire.ConcreteType{O: ExpireEscrowTx{}, Byte: 0x03},
+		wire.ConcreteType{O: ReleaseEscrowTx{}, Byte: 0x04},
+		wire.ConcreteType{O: SplitEscrowTx{}, Byte: 0x05},
+		wire.ConcreteType{O: CreateHashlockEscrowTx{}, Byte: 0x06},
+		wire.ConcreteType{O: ClaimEscrowTx{}, Byte: 0x07},
+		wire.ConcreteType{O: ApproveEscrowTx{}, Byte: 0x08},
+		wire.ConcreteType{O: DisputeEscrowTx{}, Byte: 0x09},
+		wire.ConcreteType{O: CreateStreamEscrowTx{}, Byte: 0x0A},
+		wire.ConcreteType{O: WithdrawEscrowTx{}, Byte: 0x0B},
+		wire.ConcreteType{O: CancelStreamTx{}, Byte: 0x0C},
+		wire.ConcreteType{O: TopUpEscrowTx{}, Byte: 0x0D},
+		wire.ConcreteType{O: AmendEscrowTx{}, Byte: 0x0E},
 	)
 }
 
@@ -38,12 +52,24 @@ func EscrowTxBytes(tx EscrowTx) []byte {
 type CreateEscrowTx struct {
 	Recipient  []byte
 	Arbiter    []byte
-	Expiration uint64 // height when the offer expires
+	Arbiters   [][]byte // set instead of Arbiter to have multiple arbiters
+	Threshold  int      // how many of the Arbiters must agree to resolve
+	Expiration uint64   // height when the offer expires
+	// optional fee for a single Arbiter, either fixed or in basis points
+	// of the amount, paid out of the escrow when the Arbiter resolves it
+	ArbiterFee    types.Coins
+	ArbiterFeeBps int
+	// to allow disputes, the blocks the Arbiter has to decide one, and
+	// who gets the money if they don't
+	DecisionPeriod uint64
+	FallbackPayout bool
 	// Sender and Amount come from the basecoin context
 }
 
 // ResolveEscrowTx must be signed by the Arbiter and resolves the escrow
-// by sending the money to Sender or Recipient as specified
+// by sending the money to Sender or Recipient as specified.
+// With multiple Arbiters, it is a vote and the escrow only resolves
+// once Threshold of them agree
 type ResolveEscrowTx struct {
 	Escrow []byte
 	Payout bool // if true, to Recipient, else back to Sender
@@ -51,28 +77,378 @@ type ResolveEscrowTx struct {
 
 // ExpireEscrowTx can be signed by anyone, and only succeeds if the
 // Expiration height has passed.  All coins go back to the Sender
-// (Intended to be used by the sender to recover old payments)
+// (Intended to be used by the sender to recover old payments).
+// For a dispute, it waits for the Deadline and applies the fallback rule
 type ExpireEscrowTx struct {
 	Escrow []byte
 }
 
+// ReleaseEscrowTx must be signed by the Arbiter and pays a part of the
+// escrow to the Recipient (eg. for a milestone), the rest stays locked.
+// It is only supported for escrows with a single Arbiter
+type ReleaseEscrowTx struct {
+	Escrow []byte
+	Amount types.Coins // must not exceed the remaining Amount of the escrow
+}
+
+// SplitEscrowTx must be signed by the Arbiter and resolves the escrow
+// by sharing the money between Recipient and Sender, for a compromise.
+// It is only supported for escrows with a single Arbiter
+type SplitEscrowTx struct {
+	Escrow      []byte
+	ToRecipient types.Coins // together these must sum to the
+	ToSender    types.Coins // remaining Amount of the escrow
+}
+
+// CreateHashlockEscrowTx creates an escrow without an arbiter for atomic
+// swaps.  The Recipient can claim it by revealing the preimage of the
+// Hashlock, after Expiration the Sender can get it back with ExpireEscrowTx
+type CreateHashlockEscrowTx struct {
+	Recipient  []byte
+	Hashlock   []byte // sha256 hash of the secret preimage
+	Expiration uint64 // height when the sender can get a refund, required
+	// Sender and Amount come from the basecoin context
+}
+
+// ClaimEscrowTx must be signed by the Recipient and pays out a hashlock
+// escrow before it expires, if the Preimage matches the Hashlock
+type ClaimEscrowTx struct {
+	Escrow   []byte
+	Preimage []byte
+}
+
+// ApproveEscrowTx must be signed by the Sender or Recipient.  Once both
+// of them approve the same outcome, the escrow is settled without the Arbiter
+type ApproveEscrowTx struct {
+	Escrow []byte
+	Payout bool // if true, to Recipient, else back to Sender
+}
+
+// limits on the dispute data stored with the escrow
+const (
+	MaxEvidenceLength = 64
+	MaxMemoLength     = 256
+)
+
+// DisputeEscrowTx must be signed by the Sender or Recipient and raises
+// a dispute, the Arbiter must then decide within the DecisionPeriod or
+// the escrow is settled by the fallback rule
+type DisputeEscrowTx struct {
+	Escrow   []byte
+	Evidence []byte // hash of the evidence kept elsewhere
+	Memo     string
+}
+
+// CreateStreamEscrowTx creates an escrow without an arbiter for payments
+// in installments, the money vests linearly from StartHeight to EndHeight
+type CreateStreamEscrowTx struct {
+	Recipient   []byte
+	StartHeight uint64
+	EndHeight   uint64
+	// Sender and Amount come from the basecoin context
+}
+
+// WithdrawEscrowTx must be signed by the Recipient and pays out
+// everything vested so far in a stream
+type WithdrawEscrowTx struct {
+	Escrow []byte
+}
+
+// CancelStreamTx must be signed by the Sender and ends a stream, what is
+// vested goes to the Recipient, the rest back to the Sender
+type CancelStreamTx struct {
+	Escrow []byte
+}
+
+// TopUpEscrowTx must be signed by the Sender and adds the coins sent
+// to the escrow, at the same address
+type TopUpEscrowTx struct {
+	Escrow []byte
+}
+
+// AmendEscrowTx changes a running escrow at the same address.  Extending
+// the Expiration must be signed by the Sender, a new Arbiter must be
+// proposed by the Sender or Recipient and confirmed by the other one
+type AmendEscrowTx struct {
+	Escrow     []byte
+	Expiration uint64 // new, later expiration, 0 to keep it
+	Arbiter    []byte // new arbiter, empty to keep it
+}
+
 // EscrowData is our principal data structure in the db
 type EscrowData struct {
 	Sender     []byte
+	Serial     int // this sequence number is from the apptx that created it
 	Recipient  []byte
 	Arbiter    []byte
-	Expiration uint64 // height when the offer expires (0 = never)
-	Amount     types.Coins
+	Expiration uint64        // height when the offer expires (0 = never)
+	Amount     types.Coins   // remaining coins locked in the escrow
+	Released   types.Coins   // coins already paid to the Recipient
+	Arbiters   [][]byte      // set instead of Arbiter for multiple arbiters
+	Threshold  int           // how many of the Arbiters must agree
+	Votes      []ArbiterVote // the decisions of the Arbiters so far
+	Hashlock   []byte        // set for escrows claimed with a preimage
+	Approvals  []Approval    // the decisions of Sender and Recipient so far
+	ArbiterFee types.Coins   // paid to the Arbiter out of Amount on resolution
+	// set if the ArbiterFee is relative, so it grows with a top-up
+	ArbiterFeeBps int
+	// disputes are only allowed with a DecisionPeriod
+	DecisionPeriod uint64 // blocks the Arbiter has to decide a dispute
+	FallbackPayout bool   // if the Arbiter doesn't, true pays Recipient
+	State          EscrowState
+	Dispute        EscrowDispute // set once the State is EscrowDisputed
+	// set for streams, the coins vest linearly between these heights
+	StreamStart uint64
+	StreamEnd   uint64
+	// proposed by one party, waiting for the other one
+	Amendment EscrowAmendment
+}
+
+// EscrowAmendment is a change proposed by the Sender or Recipient
+type EscrowAmendment struct {
+	Proposer   []byte
+	Expiration uint64
+	Arbiter    []byte
+}
+
+// Confirms is true if other is the same amendment proposed
+// by the other party
+func (a EscrowAmendment) Confirms(other EscrowAmendment) bool {
+	return len(a.Proposer) > 0 &&
+		!bytes.Equal(a.Proposer, other.Proposer) &&
+		a.Expiration == other.Expiration &&
+		bytes.Equal(a.Arbiter, other.Arbiter)
+}
+
+// EscrowState tracks the escrow as disputes are raised
+type EscrowState byte
+
+const (
+	EscrowOpen     EscrowState = 0x00
+	EscrowDisputed EscrowState = 0x01
+)
+
+// EscrowDispute is raised by the Sender or Recipient for the Arbiter
+type EscrowDispute struct {
+	Raiser   []byte
+	Evidence []byte
+	Memo     string
+	Deadline uint64 // the fallback rule applies after this height
+}
+
+// MaxBasisPoints is 100% in basis points
+const MaxBasisPoints = 10000
+
+// BasisPoints returns bps/10000 of all coins, rounded down
+func BasisPoints(coins types.Coins, bps int) types.Coins {
+	var res types.Coins
+	for _, coin := range coins {
+		amount := coin.Amount * int64(bps) / MaxBasisPoints
+		if amount != 0 {
+			res = append(res, types.Coin{Denom: coin.Denom, Amount: amount})
+		}
+	}
+	return res
+}
+
+// ArbiterVote is the decision of one of multiple arbiters
+type ArbiterVote struct {
+	Arbiter []byte
+	Payout  bool // if true, to Recipient, else back to Sender
+}
+
+// Approval is the decision of the Sender or Recipient
+type Approval struct {
+	Party  []byte
+	Payout bool // if true, to Recipient, else back to Sender
 }
 
 func (d EscrowData) IsExpired(h uint64) bool {
 	return (d.Expiration != 0 && h > d.Expiration)
 }
 
-// Address is the ripemd160 hash of the escrow contents, which is constant
+// IsStream is true if the escrow is paid in installments
+func (d EscrowData) IsStream() bool {
+	return d.StreamEnd > 0
+}
+
+// Vested is the share of all streamed coins that the Recipient earned
+// by height h, whether already withdrawn or not
+func (d EscrowData) Vested(h uint64) types.Coins {
+	total := d.Amount.Plus(d.Released)
+	switch {
+	case !d.IsStream() || h <= d.StreamStart:
+		return nil
+	case h >= d.StreamEnd:
+		return total
+	}
+
+	// the product can overflow an int64, but the result is below the total
+	elapsed := new(big.Int).SetUint64(h - d.StreamStart)
+	period := new(big.Int).SetUint64(d.StreamEnd - d.StreamStart)
+	var vested types.Coins
+	for _, coin := range total {
+		amount := new(big.Int).Mul(big.NewInt(coin.Amount), elapsed)
+		amount.Quo(amount, period)
+		if amount.Sign() > 0 {
+			vested = append(vested, types.Coin{Denom: coin.Denom, Amount: amount.Int64()})
+		}
+	}
+	return vested
+}
+
+// Withdrawable is what the Recipient can withdraw from a stream at height h
+func (d EscrowData) Withdrawable(h uint64) types.Coins {
+	return d.Vested(h).Minus(d.Released)
+}
+
+// IsDisputed is true once a dispute was raised
+func (d EscrowData) IsDisputed() bool {
+	return d.State == EscrowDisputed
+}
+
+// ExpiryHeight is the height after which the escrow can be expired,
+// for a dispute this is the Deadline instead of the Expiration
+func (d EscrowData) ExpiryHeight() uint64 {
+	if d.IsDisputed() {
+		return d.Dispute.Deadline
+	}
+	return d.Expiration
+}
+
+// CanExpire is true if the escrow can be settled without a decision,
+// a dispute must wait for the Arbiter until the Deadline
+func (d EscrowData) CanExpire(h uint64) bool {
+	if d.IsDisputed() {
+		return h > d.Dispute.Deadline
+	}
+	return d.IsExpired(h)
+}
+
+// ExpirePayee gets the money if the escrow expires, this is the Sender
+// unless the fallback rule of a dispute pays the Recipient
+func (d EscrowData) ExpirePayee() []byte {
+	if d.IsDisputed() && d.FallbackPayout {
+		return d.Recipient
+	}
+	return d.Sender
+}
+
+// AmountAfterFee is what is paid out when the Arbiter resolves the escrow
+func (d EscrowData) AmountAfterFee() types.Coins {
+	return d.Amount.Minus(d.ArbiterFee)
+}
+
+// IsParty checks if addr is the Sender or Recipient
+func (d EscrowData) IsParty(addr []byte) bool {
+	return bytes.Equal(addr, d.Sender) || bytes.Equal(addr, d.Recipient)
+}
+
+// AddApproval records the decision of the Sender or Recipient, replacing
+// any earlier one, and returns true if both now agree on the outcome
+func (d *EscrowData) AddApproval(approval Approval) bool {
+	approvals := []Approval{approval}
+	for _, a := range d.Approvals {
+		if !bytes.Equal(a.Party, approval.Party) {
+			approvals = append(approvals, a)
+		}
+	}
+	d.Approvals = approvals
+	return len(approvals) == 2 && approvals[0].Payout == approvals[1].Payout
+}
+
+// IsHashlock is true if the escrow is claimed with a preimage, not
+// resolved by an arbiter
+func (d EscrowData) IsHashlock() bool {
+	return len(d.Hashlock) > 0
+}
+
+// CheckPreimage is true if the sha256 hash of preimage is the Hashlock
+func (d EscrowData) CheckPreimage(preimage []byte) bool {
+	if !d.IsHashlock() {
+		return false
+	}
+	hash := sha256.Sum256(preimage)
+	return bytes.Equal(hash[:], d.Hashlock)
+}
+
+// HasArbiters is true if the escrow is resolved by multiple arbiters
+func (d EscrowData) HasArbiters() bool {
+	return len(d.Arbiters) > 0
+}
+
+// IsArbiter checks if addr is the Arbiter, or one of the Arbiters
+func (d EscrowData) IsArbiter(addr []byte) bool {
+	if !d.HasArbiters() {
+		return bytes.Equal(addr, d.Arbiter)
+	}
+	for _, arb := range d.Arbiters {
+		if bytes.Equal(addr, arb) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddVote records the decision of an arbiter, replacing any earlier vote,
+// and returns how many arbiters now agree with this decision
+func (d *EscrowData) AddVote(vote ArbiterVote) int {
+	votes := []ArbiterVote{vote}
+	agree := 1
+	for _, v := range d.Votes {
+		if bytes.Equal(v.Arbiter, vote.Arbiter) {
+			continue
+		}
+		if v.Payout == vote.Payout {
+			agree++
+		}
+		votes = append(votes, v)
+	}
+	d.Votes = votes
+	return agree
+}
+
+// EscrowSenderKey stores the list of open escrows sent by addr
+func EscrowSenderKey(addr []byte) []byte {
+	return append([]byte("sender/"), addr...)
+}
+
+// EscrowRecipientKey stores the list of open escrows received by addr
+func EscrowRecipientKey(addr []byte) []byte {
+	return append([]byte("recipient/"), addr...)
+}
+
+// EscrowArbiterKey stores the list of open escrows arbitrated by addr
+func EscrowArbiterKey(addr []byte) []byte {
+	return append([]byte("arbiter/"), addr...)
+}
+
+// IndexKeys are the keys of all party indexes that list this escrow
+func (d EscrowData) IndexKeys() [][]byte {
+	keys := [][]byte{EscrowSenderKey(d.Sender), EscrowRecipientKey(d.Recipient)}
+	if len(d.Arbiter) > 0 {
+		keys = append(keys, EscrowArbiterKey(d.Arbiter))
+	}
+	for _, arb := range d.Arbiters {
+		keys = append(keys, EscrowArbiterKey(arb))
+	}
+	return keys
+}
+
+// Address is the ripemd160 hash of the Sender and Serial, so every escrow
+// gets a new address and keeps it even if parts are released later
 func (d EscrowData) Address() []byte {
+	return EscrowAddress(d.Sender, d.Serial)
+}
+
+// EscrowAddress is the address of the escrow created by sender in the
+// apptx with the given sequence number
+func EscrowAddress(sender []byte, serial int) []byte {
 	hasher := ripemd160.New()
-	hasher.Write(d.Bytes())
+	hasher.Write(wire.BinaryBytes(struct {
+		Sender []byte
+		Serial int
+	}{sender, serial}))
 	return hasher.Sum(nil)
 }
 
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"crypto/sha256"
 	"strconv"
 	"testing"
 
@@ -20,10 +21,41 @@ func TestEscrowData(t *testing.T) {
 				Denom:  "ATOM",
 			},
 		},
+		Released: bc.Coins{
+			{
+				Amount: 250,
+				Denom:  "ATOM",
+			},
+		},
+		// empty lists are read back as empty, not nil
+		Arbiters:  [][]byte{},
+		Votes:     []ArbiterVote{},
+		Hashlock:  []byte{},
+		Approvals: []Approval{},
+		ArbiterFee: bc.Coins{
+			{
+				Amount: 10,
+				Denom:  "ATOM",
+			},
+		},
+		DecisionPeriod: 20,
+		State:          EscrowDisputed,
+		Dispute: EscrowDispute{
+			Raiser:   []byte("1234567890qwertyuiop"),
+			Evidence: []byte("evidence hash"),
+			Memo:     "never delivered",
+			Deadline: 150,
+		},
+		Amendment: EscrowAmendment{
+			Proposer:   []byte("AS1234567890qwertyui"),
+			Expiration: 300,
+			Arbiter:    []byte("1234567890ASDFqwerty"),
+		},
 	}
 
 	// make sure expiration only has meaning if non-zero
 	assert.False(data.IsExpired(100))
+	assert.False(data.IsExpired(300))
 	data.Expiration = 200
 	assert.False(data.IsExpired(100))
 	assert.True(data.IsExpired(201))
@@ -42,6 +74,12 @@ func TestEscrowData(t *testing.T) {
 
 	// and make sure they have the same address
 	assert.Equal(addr, d2.Address())
+
+	// which only depends on sender and serial
+	d2.Amount = d2.Released
+	assert.Equal(addr, d2.Address())
+	d2.Serial++
+	assert.NotEqual(addr, d2.Address())
 }
 
 func TestEscrowTxParse(t *testing.T) {
@@ -49,7 +87,9 @@ func TestEscrowTxParse(t *testing.T) {
 	ctx := CreateEscrowTx{
 		Recipient:  []byte("AS1234567890qwertyui"),
 		Arbiter:    []byte("ASDF1234567890qwerty"),
+		Arbiters:   [][]byte{},
 		Expiration: 12345,
+		ArbiterFee: bc.Coins{{Amount: 5, Denom: "ATOM"}},
 	}
 	rtx := ResolveEscrowTx{
 		Escrow: []byte("1234567890qwertyuiop"),
@@ -58,9 +98,56 @@ func TestEscrowTxParse(t *testing.T) {
 	etx := ExpireEscrowTx{
 		Escrow: []byte("1234567890qwertyuiop"),
 	}
+	reltx := ReleaseEscrowTx{
+		Escrow: []byte("1234567890qwertyuiop"),
+		Amount: bc.Coins{{Amount: 50, Denom: "ATOM"}},
+	}
+	stx := SplitEscrowTx{
+		Escrow:      []byte("1234567890qwertyuiop"),
+		ToRecipient: bc.Coins{{Amount: 30, Denom: "ATOM"}},
+		ToSender:    bc.Coins{{Amount: 20, Denom: "ATOM"}},
+	}
+	htx := CreateHashlockEscrowTx{
+		Recipient:  []byte("AS1234567890qwertyui"),
+		Hashlock:   []byte("1234567890qwertyuiop1234567890qw"),
+		Expiration: 12345,
+	}
+	cltx := ClaimEscrowTx{
+		Escrow:   []byte("1234567890qwertyuiop"),
+		Preimage: []byte("secret"),
+	}
+	atx := ApproveEscrowTx{
+		Escrow: []byte("1234567890qwertyuiop"),
+		Payout: true,
+	}
+	dtx := DisputeEscrowTx{
+		Escrow:   []byte("1234567890qwertyuiop"),
+		Evidence: []byte("evidence hash"),
+		Memo:     "never delivered",
+	}
+	sctx := CreateStreamEscrowTx{
+		Recipient:   []byte("AS1234567890qwertyui"),
+		StartHeight: 100,
+		EndHeight:   200,
+	}
+	wtx := WithdrawEscrowTx{
+		Escrow: []byte("1234567890qwertyuiop"),
+	}
+	cstx := CancelStreamTx{
+		Escrow: []byte("1234567890qwertyuiop"),
+	}
+	ttx := TopUpEscrowTx{
+		Escrow: []byte("1234567890qwertyuiop"),
+	}
+	amtx := AmendEscrowTx{
+		Escrow:     []byte("1234567890qwertyuiop"),
+		Expiration: 300,
+		Arbiter:    []byte("1234567890ASDFqwerty"),
+	}
 
 	// make sure all of them serialize and deserialize fine
-	txs := []EscrowTx{ctx, rtx, etx}
+	txs := []EscrowTx{ctx, rtx, etx, reltx, stx, htx, cltx, atx, dtx,
+		sctx, wtx, cstx, ttx, amtx}
 	for i, tx := range txs {
 		idx := strconv.Itoa(i)
 		b := EscrowTxBytes(tx)
@@ -73,3 +160,176 @@ func TestEscrowTxParse(t *testing.T) {
 	}
 
 }
+
+func TestArbiterVotes(t *testing.T) {
+	assert := assert.New(t)
+	a, b, c := []byte("1234567890qwertyuiop"), []byte("AS1234567890qwertyui"), []byte("ASDF1234567890qwerty")
+	data := EscrowData{
+		Arbiters:  [][]byte{a, b},
+		Threshold: 2,
+	}
+	assert.True(data.HasArbiters())
+	assert.True(data.IsArbiter(a))
+	assert.True(data.IsArbiter(b))
+	assert.False(data.IsArbiter(c))
+	assert.False(data.IsArbiter(nil))
+
+	// votes are counted per decision
+	assert.Equal(1, data.AddVote(ArbiterVote{Arbiter: a, Payout: true}))
+	assert.Equal(1, data.AddVote(ArbiterVote{Arbiter: b, Payout: false}))
+	assert.Equal(2, len(data.Votes))
+
+	// and a new vote replaces the old one of the same arbiter
+	assert.Equal(2, data.AddVote(ArbiterVote{Arbiter: a, Payout: false}))
+	assert.Equal(2, len(data.Votes))
+
+	// votes are stored with the escrow
+	d2, err := ParseEscrow(data.Bytes())
+	assert.Nil(err)
+	assert.Equal(data.Votes, d2.Votes)
+
+	// a single arbiter is also recognized
+	single := EscrowData{Arbiter: c}
+	assert.False(single.HasArbiters())
+	assert.True(single.IsArbiter(c))
+	assert.False(single.IsArbiter(a))
+}
+
+func TestHashlock(t *testing.T) {
+	assert := assert.New(t)
+	preimage := []byte("my secret")
+	hash := sha256.Sum256(preimage)
+
+	// no hashlock, no preimage matches
+	data := EscrowData{}
+	assert.False(data.IsHashlock())
+	assert.False(data.CheckPreimage(nil))
+	assert.False(data.CheckPreimage(preimage))
+
+	data.Hashlock = hash[:]
+	assert.True(data.IsHashlock())
+	assert.True(data.CheckPreimage(preimage))
+	assert.False(data.CheckPreimage([]byte("not my secret")))
+	assert.False(data.CheckPreimage(hash[:]))
+}
+
+func TestApprovals(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb := []byte("1234567890qwertyuiop"), []byte("AS1234567890qwertyui"), []byte("ASDF1234567890qwerty")
+	data := EscrowData{
+		Sender:    sender,
+		Recipient: recv,
+		Arbiter:   arb,
+	}
+	assert.True(data.IsParty(sender))
+	assert.True(data.IsParty(recv))
+	assert.False(data.IsParty(arb))
+
+	// both must agree
+	assert.False(data.AddApproval(Approval{Party: sender, Payout: true}))
+	assert.False(data.AddApproval(Approval{Party: recv, Payout: false}))
+	assert.Equal(2, len(data.Approvals))
+
+	// changing the mind replaces the old approval
+	assert.True(data.AddApproval(Approval{Party: sender, Payout: false}))
+	assert.Equal(2, len(data.Approvals))
+}
+
+func TestArbiterFee(t *testing.T) {
+	assert := assert.New(t)
+	amount := bc.Coins{{Amount: 1000, Denom: "ATOM"}, {Amount: 30, Denom: "BTC"}}
+
+	// basis points round down and drop empty coins
+	assert.Equal(bc.Coins{{Amount: 25, Denom: "ATOM"}}, BasisPoints(amount, 250))
+	assert.Equal(amount, BasisPoints(amount, MaxBasisPoints))
+	assert.Empty(BasisPoints(amount, 0))
+
+	// the fee is taken from the amount
+	data := EscrowData{Amount: amount}
+	assert.Equal(amount, data.AmountAfterFee())
+	data.ArbiterFee = BasisPoints(amount, 250)
+	assert.Equal(bc.Coins{{Amount: 975, Denom: "ATOM"}, {Amount: 30, Denom: "BTC"}},
+		data.AmountAfterFee())
+}
+
+func TestDisputeState(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv := []byte("1234567890qwertyuiop"), []byte("AS1234567890qwertyui")
+	data := EscrowData{
+		Sender:         sender,
+		Recipient:      recv,
+		Expiration:     100,
+		DecisionPeriod: 50,
+	}
+
+	// without a dispute the expiration counts
+	assert.False(data.IsDisputed())
+	assert.Equal(uint64(100), data.ExpiryHeight())
+	assert.True(data.CanExpire(101))
+	assert.Equal(sender, data.ExpirePayee())
+
+	// with a dispute the deadline
+	data.State = EscrowDisputed
+	data.Dispute = EscrowDispute{Raiser: recv, Deadline: 140}
+	assert.True(data.IsDisputed())
+	assert.Equal(uint64(140), data.ExpiryHeight())
+	assert.False(data.CanExpire(101))
+	assert.True(data.CanExpire(141))
+	assert.Equal(sender, data.ExpirePayee())
+
+	// and the fallback rule decides who gets paid
+	data.FallbackPayout = true
+	assert.Equal(recv, data.ExpirePayee())
+}
+
+func TestStreamVesting(t *testing.T) {
+	assert := assert.New(t)
+	data := EscrowData{
+		Amount:      bc.Coins{{Amount: 1000, Denom: "ATOM"}, {Amount: 3, Denom: "BTC"}},
+		StreamStart: 100,
+		StreamEnd:   200,
+	}
+	assert.True(data.IsStream())
+	assert.False(EscrowData{}.IsStream())
+
+	// nothing before the start, everything after the end
+	assert.Empty(data.Vested(50))
+	assert.Empty(data.Vested(100))
+	assert.Equal(data.Amount, data.Vested(200))
+	assert.Equal(data.Amount, data.Vested(300))
+
+	// pro rata in between, rounded down
+	assert.Equal(bc.Coins{{Amount: 250, Denom: "ATOM"}}, data.Vested(125))
+	assert.Equal(bc.Coins{{Amount: 500, Denom: "ATOM"}, {Amount: 1, Denom: "BTC"}}, data.Vested(150))
+
+	// withdrawals are not paid twice
+	data.Amount = bc.Coins{{Amount: 750, Denom: "ATOM"}, {Amount: 3, Denom: "BTC"}}
+	data.Released = bc.Coins{{Amount: 250, Denom: "ATOM"}}
+	assert.Equal(bc.Coins{{Amount: 500, Denom: "ATOM"}, {Amount: 1, Denom: "BTC"}}, data.Vested(150))
+	assert.Equal(bc.Coins{{Amount: 250, Denom: "ATOM"}, {Amount: 1, Denom: "BTC"}}, data.Withdrawable(150))
+	assert.Empty(data.Withdrawable(125))
+
+	// large amounts and long streams don't overflow
+	huge := EscrowData{
+		Amount:      bc.Coins{{Amount: 1 << 62, Denom: "ATOM"}},
+		StreamStart: 0,
+		StreamEnd:   1 << 40,
+	}
+	assert.Equal(bc.Coins{{Amount: 1 << 61, Denom: "ATOM"}}, huge.Vested(1<<39))
+	assert.Equal(bc.Coins{{Amount: 1<<62 - 1<<22, Denom: "ATOM"}}, huge.Vested(1<<40-1))
+}
+
+func TestAmendment(t *testing.T) {
+	assert := assert.New(t)
+	sender, recv, arb := []byte("1234567890qwertyuiop"), []byte("AS1234567890qwertyui"), []byte("ASDF1234567890qwerty")
+	proposal := EscrowAmendment{Proposer: sender, Expiration: 300, Arbiter: arb}
+
+	// nothing proposed yet
+	assert.False(EscrowAmendment{}.Confirms(proposal))
+
+	// the other party must confirm the same change
+	assert.False(proposal.Confirms(proposal))
+	assert.True(proposal.Confirms(EscrowAmendment{Proposer: recv, Expiration: 300, Arbiter: arb}))
+	assert.False(proposal.Confirms(EscrowAmendment{Proposer: recv, Expiration: 400, Arbiter: arb}))
+	assert.False(proposal.Confirms(EscrowAmendment{Proposer: recv, Expiration: 300, Arbiter: recv}))
+}
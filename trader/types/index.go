@@ -0,0 +1,161 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/tendermint/basecoin/types"
+	wire "github.com/tendermint/go-wire"
+)
+
+// AddrList is a list of addresses stored under one key,
+// as the store cannot iterate over a range of keys
+type AddrList [][]byte
+
+func LoadAddrList(store types.KVStore, key []byte) AddrList {
+	var list AddrList
+	data := store.Get(key)
+	if len(data) > 0 {
+		if err := wire.ReadBinaryBytes(data, &list); err != nil {
+			return nil
+		}
+	}
+	return list
+}
+
+// Save stores the list, an empty list is removed from the store
+func (l AddrList) Save(store types.KVStore, key []byte) {
+	if len(l) == 0 {
+		store.Set(key, nil)
+		return
+	}
+	store.Set(key, wire.BinaryBytes(l))
+}
+
+func (l AddrList) Has(addr []byte) bool {
+	for _, a := range l {
+		if bytes.Equal(a, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends addr if it is not yet in the list
+func (l AddrList) Add(addr []byte) AddrList {
+	if l.Has(addr) {
+		return l
+	}
+	return append(l, addr)
+}
+
+func (l AddrList) Remove(addr []byte) AddrList {
+	res := AddrList{}
+	for _, a := range l {
+		if !bytes.Equal(a, addr) {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// HeightIndex keeps addresses ordered by a block height (eg. expiration),
+// so the ones due can be found without scanning all data
+type HeightIndex struct {
+	prefix string
+}
+
+func NewHeightIndex(prefix string) HeightIndex {
+	return HeightIndex{prefix: prefix}
+}
+
+// the sorted list of all heights with addresses
+func (i HeightIndex) heightsKey() []byte {
+	return []byte(i.prefix + "heights")
+}
+
+// the addresses for one height
+func (i HeightIndex) key(h uint64) []byte {
+	return []byte(fmt.Sprintf("%s%d", i.prefix, h))
+}
+
+func (i HeightIndex) loadHeights(store types.KVStore) []uint64 {
+	var heights []uint64
+	data := store.Get(i.heightsKey())
+	if len(data) > 0 {
+		if err := wire.ReadBinaryBytes(data, &heights); err != nil {
+			return nil
+		}
+	}
+	return heights
+}
+
+func (i HeightIndex) saveHeights(store types.KVStore, heights []uint64) {
+	if len(heights) == 0 {
+		store.Set(i.heightsKey(), nil)
+		return
+	}
+	store.Set(i.heightsKey(), wire.BinaryBytes(heights))
+}
+
+// Add indexes addr at height h
+func (i HeightIndex) Add(store types.KVStore, h uint64, addr []byte) {
+	list := LoadAddrList(store, i.key(h))
+	if len(list) == 0 {
+		// insert the new height in order
+		heights := i.loadHeights(store)
+		pos := len(heights)
+		for j, height := range heights {
+			if height > h {
+				pos = j
+				break
+			}
+		}
+		heights = append(heights, 0)
+		copy(heights[pos+1:], heights[pos:])
+		heights[pos] = h
+		i.saveHeights(store, heights)
+	}
+	list.Add(addr).Save(store, i.key(h))
+}
+
+// Remove takes addr out of the index at height h
+func (i HeightIndex) Remove(store types.KVStore, h uint64, addr []byte) {
+	list := LoadAddrList(store, i.key(h))
+	if !list.Has(addr) {
+		return
+	}
+	list = list.Remove(addr)
+	list.Save(store, i.key(h))
+	if len(list) == 0 {
+		heights := i.loadHeights(store)
+		for j, height := range heights {
+			if height == h {
+				heights = append(heights[:j], heights[j+1:]...)
+				break
+			}
+		}
+		i.saveHeights(store, heights)
+	}
+}
+
+// Pop removes and returns up to max addresses indexed at heights below h,
+// lowest heights first.  The rest stays in the index for later
+func (i HeightIndex) Pop(store types.KVStore, h uint64, max int) [][]byte {
+	var res [][]byte
+	heights := i.loadHeights(store)
+	for len(heights) > 0 && heights[0] < h && len(res) < max {
+		key := i.key(heights[0])
+		list := LoadAddrList(store, key)
+		if n := max - len(res); len(list) > n {
+			res = append(res, list[:n]...)
+			list[n:].Save(store, key)
+			break
+		}
+		res = append(res, list...)
+		store.Set(key, nil)
+		heights = heights[1:]
+	}
+	i.saveHeights(store, heights)
+	return res
+}
@@ -0,0 +1,59 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bc "github.com/tendermint/basecoin/types"
+)
+
+func TestAddrList(t *testing.T) {
+	assert := assert.New(t)
+	store := bc.NewMemKVStore()
+	key := []byte("list")
+	a, b := []byte("1234567890qwertyuiop"), []byte("AS1234567890qwertyui")
+
+	list := LoadAddrList(store, key)
+	assert.Empty(list)
+
+	// no duplicates
+	list = list.Add(a).Add(b).Add(a)
+	assert.Equal(2, len(list))
+	list.Save(store, key)
+	assert.Equal(list, LoadAddrList(store, key))
+
+	// an empty list is removed from the store
+	list = list.Remove(a)
+	assert.False(list.Has(a))
+	assert.True(list.Has(b))
+	list.Remove(b).Save(store, key)
+	assert.Nil(store.Get(key))
+}
+
+func TestHeightIndex(t *testing.T) {
+	assert := assert.New(t)
+	store := bc.NewMemKVStore()
+	idx := NewHeightIndex("idx/")
+	a, b, c, d := []byte("a"), []byte("b"), []byte("c"), []byte("d")
+
+	idx.Add(store, 30, c)
+	idx.Add(store, 10, a)
+	idx.Add(store, 20, b)
+	idx.Add(store, 10, d)
+	assert.Equal([]uint64{10, 20, 30}, idx.loadHeights(store))
+
+	// removing the last address drops the height
+	idx.Remove(store, 20, b)
+	assert.Equal([]uint64{10, 30}, idx.loadHeights(store))
+
+	// nothing is due at or before the lowest height
+	assert.Empty(idx.Pop(store, 10, 5))
+
+	// only up to max are returned, the rest stays
+	assert.Equal([][]byte{a}, idx.Pop(store, 100, 1))
+	assert.Equal([]uint64{10, 30}, idx.loadHeights(store))
+	assert.Equal([][]byte{d}, idx.Pop(store, 20, 5))
+	assert.Equal([][]byte{c}, idx.Pop(store, 100, 5))
+	assert.Empty(idx.loadHeights(store))
+	assert.Nil(store.Get(idx.heightsKey()))
+}
@@ -0,0 +1,292 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/tendermint/basecoin/types"
+	wire "github.com/tendermint/go-wire"
+)
+
+// OptionsForSaleKey stores the SaleIndex of all options offered for sale
+var OptionsForSaleKey = []byte("forsale")
+
+// OptionsForSalePairKey stores the SaleIndex of the options for sale
+// that bond one denom and are exercised with another
+func OptionsForSalePairKey(bondDenom, tradeDenom string) []byte {
+	return []byte(fmt.Sprintf("forsale/%s/%s", bondDenom, tradeDenom))
+}
+
+// OptionHolderKey stores the list of options held by addr
+func OptionHolderKey(addr []byte) []byte {
+	return append([]byte("holder/"), addr...)
+}
+
+// IsForSale is true once the holder set a Price
+func (d OptionData) IsForSale() bool {
+	return !d.Price.IsZero()
+}
+
+// AskingPrice is the fixed Price, or the lowest bid an auction accepts
+func (d OptionData) AskingPrice() types.Coins {
+	if d.IsAuction() {
+		return types.Coins{d.Auction.MinBid()}
+	}
+	return d.Price
+}
+
+// SaleKeys are the keys of all sale indexes that list this option,
+// both fixed price sales and auctions are listed for sale
+func (d OptionData) SaleKeys() [][]byte {
+	if !d.IsForSale() && !d.IsAuction() {
+		return nil
+	}
+	keys := [][]byte{OptionsForSaleKey}
+	for _, bond := range d.Bond {
+		for _, trade := range d.Trade {
+			keys = append(keys, OptionsForSalePairKey(bond.Denom, trade.Denom))
+		}
+	}
+	return keys
+}
+
+// SaleEntry summarizes the option for the sale indexes
+func (d OptionData) SaleEntry() SaleEntry {
+	return SaleEntry{
+		Address:    d.Address(),
+		Bond:       d.Bond,
+		Trade:      d.Trade,
+		Price:      d.AskingPrice(),
+		Expiration: d.Expiration,
+	}
+}
+
+// SaleEntry is what the sale indexes know of an option, enough to
+// narrow down the offers without loading every option
+type SaleEntry struct {
+	Address    []byte
+	Bond       types.Coins
+	Trade      types.Coins
+	Price      types.Coins // the asking price
+	Expiration uint64
+}
+
+// SaleIndex lists the options for sale under one key, ordered by
+// price and then expiration
+type SaleIndex []SaleEntry
+
+func LoadSaleIndex(store types.KVStore, key []byte) SaleIndex {
+	var idx SaleIndex
+	data := store.Get(key)
+	if len(data) > 0 {
+		if err := wire.ReadBinaryBytes(data, &idx); err != nil {
+			return nil
+		}
+	}
+	return idx
+}
+
+// Save stores the index, an empty index is removed from the store
+func (idx SaleIndex) Save(store types.KVStore, key []byte) {
+	if len(idx) == 0 {
+		store.Set(key, nil)
+		return
+	}
+	store.Set(key, wire.BinaryBytes(idx))
+}
+
+func (idx SaleIndex) Has(addr []byte) bool {
+	for _, e := range idx {
+		if bytes.Equal(e.Address, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add inserts the entry in order, replacing an older one for the address
+func (idx SaleIndex) Add(entry SaleEntry) SaleIndex {
+	idx = idx.Remove(entry.Address)
+	pos := len(idx)
+	for i, e := range idx {
+		if compareSaleEntries(entry, e) < 0 {
+			pos = i
+			break
+		}
+	}
+	idx = append(idx, SaleEntry{})
+	copy(idx[pos+1:], idx[pos:])
+	idx[pos] = entry
+	return idx
+}
+
+func (idx SaleIndex) Remove(addr []byte) SaleIndex {
+	res := SaleIndex{}
+	for _, e := range idx {
+		if !bytes.Equal(e.Address, addr) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func compareSaleEntries(a, b SaleEntry) int {
+	if c := compareCoins(a.Price, b.Price); c != 0 {
+		return c
+	}
+	return compareHeight(a.Expiration, b.Expiration)
+}
+
+// OptionListing is an option along with its address
+type OptionListing struct {
+	Address []byte
+	Option  OptionData
+}
+
+// SaleFilter selects the listings a buyer is interested in,
+// empty fields match everything
+type SaleFilter struct {
+	BondDenom     string      // the Bond must contain this denom
+	TradeDenom    string      // the Trade must contain this denom
+	MaxPrice      types.Coins // the asking price may not be above this
+	ExpiresAfter  uint64      // the option must not expire before this height
+	Buyer         []byte      // only sales open to this buyer
+	IncludeClosed bool        // also list sales reserved for someone else
+	Height        uint64      // hide the offers and options expired at this height (0 = keep all)
+}
+
+func hasDenom(coins types.Coins, denom string) bool {
+	for _, coin := range coins {
+		if coin.Denom == denom {
+			return true
+		}
+	}
+	return false
+}
+
+// IndexKey is the smallest sale index with all listings that can match
+func (f SaleFilter) IndexKey() []byte {
+	if f.BondDenom != "" && f.TradeDenom != "" {
+		return OptionsForSalePairKey(f.BondDenom, f.TradeDenom)
+	}
+	return OptionsForSaleKey
+}
+
+// MatchesEntry checks what the sale index knows of an option,
+// Matches has to check the loaded option as well
+func (f SaleFilter) MatchesEntry(e SaleEntry) bool {
+	switch {
+	case f.BondDenom != "" && !hasDenom(e.Bond, f.BondDenom):
+		return false
+	case f.TradeDenom != "" && !hasDenom(e.Trade, f.TradeDenom):
+		return false
+	case !f.MaxPrice.IsZero() && !f.MaxPrice.IsGTE(e.Price):
+		return false
+	case e.Expiration < f.ExpiresAfter:
+		return false
+	case f.Height != 0 && f.Height > e.Expiration:
+		return false
+	}
+	return true
+}
+
+// FilterEntries returns the entries matching f, in the same order
+func (f SaleFilter) FilterEntries(idx SaleIndex) SaleIndex {
+	res := SaleIndex{}
+	for _, e := range idx {
+		if f.MatchesEntry(e) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Matches is true if the listing is for sale and passes the filter
+func (f SaleFilter) Matches(l OptionListing) bool {
+	d := l.Option
+	switch {
+	case !d.IsForSale() && !d.IsAuction():
+		return false
+	case !f.MatchesEntry(d.SaleEntry()):
+		return false
+	case f.Height != 0 && d.IsSaleExpired(f.Height):
+		return false
+	case len(d.NewHolder) != 0 && !f.IncludeClosed && !d.CanBuy(f.Buyer, f.Height):
+		return false
+	}
+	return true
+}
+
+// Filter returns the listings matching f, in the same order
+func (f SaleFilter) Filter(listings []OptionListing) []OptionListing {
+	res := []OptionListing{}
+	for _, l := range listings {
+		if f.Matches(l) {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+// compareCoins orders by the first coin, by denom and then amount
+func compareCoins(a, b types.Coins) int {
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	case a[0].Denom != b[0].Denom:
+		if a[0].Denom < b[0].Denom {
+			return -1
+		}
+		return 1
+	case a[0].Amount < b[0].Amount:
+		return -1
+	case a[0].Amount > b[0].Amount:
+		return 1
+	}
+	return 0
+}
+
+func compareHeight(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// listingOrders are the ways the listings can be sorted
+var listingOrders = map[string]func(a, b OptionData) int{
+	"price":  func(a, b OptionData) int { return compareCoins(a.AskingPrice(), b.AskingPrice()) },
+	"bond":   func(a, b OptionData) int { return compareCoins(a.Bond, b.Bond) },
+	"trade":  func(a, b OptionData) int { return compareCoins(a.Trade, b.Trade) },
+	"expiry": func(a, b OptionData) int { return compareHeight(a.Expiration, b.Expiration) },
+}
+
+// SortListings orders the listings by price, bond, trade or expiry,
+// keeping the original order for equal ones
+func SortListings(listings []OptionListing, by string) error {
+	cmp, ok := listingOrders[by]
+	if !ok {
+		return fmt.Errorf("Cannot sort by %s, use price, bond, trade or expiry", by)
+	}
+	sort.Stable(byListing{listings, cmp})
+	return nil
+}
+
+type byListing struct {
+	listings []OptionListing
+	cmp      func(a, b OptionData) int
+}
+
+func (b byListing) Len() int      { return len(b.listings) }
+func (b byListing) Swap(i, j int) { b.listings[i], b.listings[j] = b.listings[j], b.listings[i] }
+func (b byListing) Less(i, j int) bool {
+	return b.cmp(b.listings[i].Option, b.listings[j].Option) < 0
+}
@@ -0,0 +1,143 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/basecoin/types"
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+func listing(bond, trade, price string, expiration uint64) OptionListing {
+	b, _ := types.ParseCoins(bond)
+	t, _ := types.ParseCoins(trade)
+	p, _ := types.ParseCoins(price)
+	return OptionListing{
+		Address: cmn.RandBytes(20),
+		Option: OptionData{
+			OptionIssue: OptionIssue{
+				Expiration: expiration,
+				Bond:       b,
+				Trade:      t,
+			},
+			OptionHolder: OptionHolder{
+				Price: p,
+			},
+		},
+	}
+}
+
+func TestOptionSaleKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	data := listing("100ETH", "2BTC", "", 100).Option
+	assert.Empty(data.SaleKeys())
+	data.Price = types.Coins{{Amount: 5, Denom: "ETH"}}
+	assert.Equal([][]byte{OptionsForSaleKey, OptionsForSalePairKey("ETH", "BTC")}, data.SaleKeys())
+}
+
+func TestSaleIndex(t *testing.T) {
+	assert := assert.New(t)
+	store := types.NewMemKVStore()
+	key := OptionsForSalePairKey("ETH", "BTC")
+
+	entry := func(price string, expiration uint64) SaleEntry {
+		l := listing("100ETH", "2BTC", price, expiration)
+		e := l.Option.SaleEntry()
+		e.Address = l.Address
+		return e
+	}
+	a, b, c := entry("10mycoin", 100), entry("5mycoin", 100), entry("10mycoin", 50)
+
+	// ordered by price and then expiration
+	idx := LoadSaleIndex(store, key).Add(a).Add(b).Add(c)
+	assert.Equal(SaleIndex{b, c, a}, idx)
+	idx.Save(store, key)
+	assert.Equal(idx, LoadSaleIndex(store, key))
+
+	// adding again moves the entry to its new place
+	b.Price = types.Coins{{Amount: 20, Denom: "mycoin"}}
+	idx = idx.Add(b)
+	assert.Equal(SaleIndex{c, a, b}, idx)
+
+	// an empty index is removed from the store
+	idx = idx.Remove(a.Address).Remove(c.Address)
+	assert.True(idx.Has(b.Address))
+	assert.False(idx.Has(a.Address))
+	idx.Remove(b.Address).Save(store, key)
+	assert.Nil(store.Get(key))
+
+	// the filter picks the smallest index and narrows it down
+	assert.Equal(key, SaleFilter{BondDenom: "ETH", TradeDenom: "BTC"}.IndexKey())
+	assert.Equal(OptionsForSaleKey, SaleFilter{BondDenom: "ETH"}.IndexKey())
+	filter := SaleFilter{MaxPrice: types.Coins{{Amount: 10, Denom: "mycoin"}}, ExpiresAfter: 80}
+	assert.Equal(SaleIndex{a}, filter.FilterEntries(SaleIndex{c, a, b}))
+}
+
+func TestSaleFilter(t *testing.T) {
+	assert := assert.New(t)
+	b := cmn.RandBytes(20)
+
+	listings := []OptionListing{
+		listing("100ETH", "2BTC", "10mycoin", 100),
+		listing("5BTC", "300ETH", "20mycoin", 200),
+		listing("100ETH", "3BTC", "5ATOM", 50),
+		listing("100ETH", "3BTC", "", 50), // not for sale
+		listing("7ETH", "3BTC", "1mycoin", 200),
+	}
+	listings[4].Option.NewHolder = b
+	listings[1].Option.SaleExpiration = 60
+
+	cases := []struct {
+		filter   SaleFilter
+		expected []int
+	}{
+		{SaleFilter{IncludeClosed: true}, []int{0, 1, 2, 4}},
+		{SaleFilter{}, []int{0, 1, 2}},
+		{SaleFilter{Buyer: b}, []int{0, 1, 2, 4}},
+		{SaleFilter{BondDenom: "ETH"}, []int{0, 2}},
+		{SaleFilter{TradeDenom: "ETH"}, []int{1}},
+		{SaleFilter{MaxPrice: types.Coins{{Amount: 15, Denom: "mycoin"}}}, []int{0}},
+		{SaleFilter{ExpiresAfter: 80}, []int{0, 1}},
+		{SaleFilter{BondDenom: "ETH", ExpiresAfter: 80, Buyer: b}, []int{0, 4}},
+		{SaleFilter{Height: 50}, []int{0, 1, 2}},
+		{SaleFilter{Height: 51}, []int{0, 1}},
+		{SaleFilter{Height: 61}, []int{0}},
+		{SaleFilter{Height: 101}, []int{}},
+	}
+	for i, tc := range cases {
+		res := tc.filter.Filter(listings)
+		if assert.Equal(len(tc.expected), len(res), "%d", i) {
+			for j, idx := range tc.expected {
+				assert.Equal(listings[idx].Address, res[j].Address, "%d/%d", i, j)
+			}
+		}
+	}
+}
+
+func TestSortListings(t *testing.T) {
+	assert := assert.New(t)
+
+	listings := []OptionListing{
+		listing("100ETH", "2BTC", "10mycoin", 100),
+		listing("5BTC", "300ETH", "20mycoin", 200),
+		listing("90ETH", "3BTC", "5mycoin", 50),
+	}
+	order := func() []string {
+		var res []string
+		for _, l := range listings {
+			res = append(res, l.Option.Bond.String())
+		}
+		return res
+	}
+
+	assert.Nil(SortListings(listings, "price"))
+	assert.Equal([]string{"90ETH", "100ETH", "5BTC"}, order())
+	assert.Nil(SortListings(listings, "expiry"))
+	assert.Equal([]string{"90ETH", "100ETH", "5BTC"}, order())
+	assert.Nil(SortListings(listings, "bond"))
+	assert.Equal([]string{"5BTC", "90ETH", "100ETH"}, order())
+	assert.Nil(SortListings(listings, "trade"))
+	assert.Equal([]string{"100ETH", "90ETH", "5BTC"}, order())
+	assert.NotNil(SortListings(listings, "color"))
+}
@@ -3,7 +3,10 @@ package types
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/big"
 
+	"github.com/pkg/errors"
 	"github.com/tendermint/basecoin/types"
 	wire "github.com/tendermint/go-wire"
 	"golang.org/x/crypto/ripemd160"
@@ -18,6 +21,9 @@ func init() {
 		wire.ConcreteType{O: BuyOptionTx{}, Byte: 0x03},
 		wire.ConcreteType{O: ExerciseOptionTx{}, Byte: 0x04},
 		wire.ConcreteType{O: DisolveOptionTx{}, Byte: 0x05},
+		wire.ConcreteType{O: AuctionOptionTx{}, Byte: 0x06},
+		wire.ConcreteType{O: BidOptionTx{}, Byte: 0x07},
+		wire.ConcreteType{O: CancelSaleTx{}, Byte: 0x08},
 	)
 }
 
@@ -37,10 +43,90 @@ func OptionsTxBytes(tx OptionsTx) []byte {
 	return wire.BinaryBytes(optionswrap{tx})
 }
 
-// CreateOptionTx is used to create an option in the first place
+// CreateOptionTx is used to create an option in the first place.
+// It replaced the free Bond and Trade coins with one Underlying coin
+// and a Strike, so options on several coins can no longer be written
 type CreateOptionTx struct {
-	Expiration uint64      // height when the offer expires
-	Trade      types.Coins // this is the money that can exercise the option
+	Type       OptionType // call to buy the Underlying, put to sell it
+	Underlying types.Coin // the coins the option is written on
+	Strike     types.Coin // price for one unit of the Underlying
+	Expiration uint64     // height when the offer expires, required
+	// optional window the option can be exercised in (0 = unbounded),
+	// eg. a European option sets both to the maturity height
+	ExerciseStart uint64
+	ExerciseEnd   uint64
+}
+
+// MaxExpirationHorizon is how many blocks ahead an option may expire,
+// so bonds aren't locked up for practically ever by mistake
+const MaxExpirationHorizon = 1000000
+
+// errors returned when validating a CreateOptionTx
+var (
+	ErrUnknownOptionType = errors.New("Unknown option type")
+	ErrInvalidUnderlying = errors.New("Underlying must be a positive coin")
+	ErrInvalidStrike     = errors.New("Strike must be a positive coin")
+	ErrSameDenom         = errors.New("Underlying and Strike need different denominations")
+	ErrStrikeOverflow    = errors.New("Strike value overflows")
+	ErrExerciseWindow    = errors.New("Invalid exercise window")
+	ErrAlreadyExpired    = errors.New("Already expired")
+	ErrExpirationHorizon = errors.New("Expiration is too far ahead")
+	ErrInsufficientBond  = errors.New("Insufficient bond")
+)
+
+// Issue is the option this tx creates for the issuer
+func (tx CreateOptionTx) Issue(issuer []byte, serial int) OptionIssue {
+	return OptionIssue{
+		Issuer:     issuer,
+		Serial:     serial,
+		Expiration: tx.Expiration,
+		Type:       tx.Type,
+		Underlying: tx.Underlying,
+		Strike:     tx.Strike,
+
+		ExerciseStart: tx.ExerciseStart,
+		ExerciseEnd:   tx.ExerciseEnd,
+	}
+}
+
+// ValidateBasic checks all that doesn't depend on the chain state,
+// so the client can do the same checks before sending the tx
+func (tx CreateOptionTx) ValidateBasic() error {
+	issue := tx.Issue(nil, 0)
+	if _, _, err := issue.Terms(); err != nil {
+		return err
+	}
+	return issue.CheckExerciseWindow()
+}
+
+// CheckHeight makes sure the option expires, but not too far, after h.
+// An option that never expires is beyond any horizon
+func (tx CreateOptionTx) CheckHeight(h uint64) error {
+	if tx.Expiration == 0 {
+		return errors.Wrap(ErrExpirationHorizon, "Options must expire")
+	}
+	if tx.Expiration < h {
+		return ErrAlreadyExpired
+	}
+	if tx.Expiration-h > MaxExpirationHorizon {
+		return errors.Wrapf(ErrExpirationHorizon, "Max %d blocks", MaxExpirationHorizon)
+	}
+	return nil
+}
+
+// CheckBond makes sure the coins sent cover the bond, and returns
+// what remains
+func (tx CreateOptionTx) CheckBond(coins types.Coins) (types.Coins, error) {
+	bond, _, err := tx.Issue(nil, 0).Terms()
+	if err != nil {
+		return nil, err
+	}
+	remain := coins.Minus(bond)
+	if !remain.IsNonnegative() {
+		return nil, errors.Wrapf(ErrInsufficientBond,
+			"A %s option must bond %s", tx.Type, bond)
+	}
+	return remain, nil
 }
 
 // SellOptionTx is used to offer the option for sale
@@ -48,6 +134,13 @@ type SellOptionTx struct {
 	Addr      []byte      // address of the refered option
 	Price     types.Coins // required payment to transfer ownership
 	NewHolder []byte      // set to allow for only one buyer, empty for any buyer
+	// optional height after which the offer can no longer be taken (0 = never)
+	Expiration uint64
+}
+
+// CancelSaleTx withdraws the offer made with SellOptionTx
+type CancelSaleTx struct {
+	Addr []byte // address of the refered option
 }
 
 // BuyOptionTx is used to purchase the right to exercise the option
@@ -55,9 +148,11 @@ type BuyOptionTx struct {
 	Addr []byte // address of the refered option
 }
 
-// ExerciseOptionTx must send Trade and recieve Bond
+// ExerciseOptionTx must send Trade and recieve Bond, in proportion
+// to the Units of the Underlying exercised
 type ExerciseOptionTx struct {
-	Addr []byte // address of the refered option
+	Addr  []byte // address of the refered option
+	Units int64  // units of the Underlying to exercise, 0 for all remaining
 }
 
 // DisolveOptionTx returns Bond to issue if expired or unpurchased
@@ -65,6 +160,20 @@ type DisolveOptionTx struct {
 	Addr []byte // address of the refered option
 }
 
+// AuctionOptionTx offers the option in an english auction, the highest
+// bid at or above Reserve by the End height wins it
+type AuctionOptionTx struct {
+	Addr    []byte     // address of the refered option
+	Reserve types.Coin // the lowest bid accepted
+	End     uint64     // last height to bid, the auction settles in this block
+}
+
+// BidOptionTx bids the coins sent on an auction, they are locked until
+// someone bids higher, or the auction ends
+type BidOptionTx struct {
+	Addr []byte // address of the refered option
+}
+
 // OptionData is our principal data structure in the db
 type OptionData struct {
 	OptionIssue
@@ -76,9 +185,43 @@ type OptionIssue struct {
 	// this is for the normal option functionality
 	Issuer     []byte
 	Serial     int         // this sequence number is from the apptx that created it
-	Expiration uint64      // height when the offer expires (0 = never)
+	Expiration uint64      // height when the option expires, always set
+	Type       OptionType  // whether the holder buys or sells the Underlying
+	Underlying types.Coin  // the coins the option is written on
+	Strike     types.Coin  // price per unit of the Underlying
 	Bond       types.Coins // this is stored upon creation of the option
 	Trade      types.Coins // this is the money that can exercise the option
+	// heights the option can be exercised between, inclusive (0 = unbounded)
+	ExerciseStart uint64
+	ExerciseEnd   uint64
+}
+
+// OptionType is the direction the holder trades the Underlying
+type OptionType byte
+
+const (
+	OptionCall OptionType = 0x00 // the holder may buy the Underlying at the Strike
+	OptionPut  OptionType = 0x01 // the holder may sell the Underlying at the Strike
+)
+
+func ParseOptionType(s string) (OptionType, error) {
+	switch s {
+	case "call":
+		return OptionCall, nil
+	case "put":
+		return OptionPut, nil
+	}
+	return 0, fmt.Errorf("Unknown option type: %s", s)
+}
+
+func (t OptionType) String() string {
+	switch t {
+	case OptionCall:
+		return "call"
+	case OptionPut:
+		return "put"
+	}
+	return fmt.Sprintf("OptionType(%d)", byte(t))
 }
 
 // OptionHolder is the dynamic section of who can excercise the options
@@ -87,10 +230,113 @@ type OptionHolder struct {
 	Holder    []byte
 	NewHolder []byte      // set to allow for only one buyer, empty for any buyer
 	Price     types.Coins // required payment to transfer ownership
+	Exercised int64       // units of the Underlying already exercised
+	Auction   OptionAuction
+	// height after which the Price can no longer be paid (0 = never)
+	SaleExpiration uint64
+}
+
+// OptionAuction is set while the option is auctioned instead of sold
+// at a fixed Price
+type OptionAuction struct {
+	Reserve types.Coin
+	End     uint64     // 0 if there is no auction
+	Bidder  []byte     // highest bidder so far, empty if no bids
+	Bid     types.Coin // the highest bid, locked in the plugin
+}
+
+func (a OptionAuction) HasBid() bool {
+	return len(a.Bidder) > 0
+}
+
+// MinBid is the lowest bid that is accepted next
+func (a OptionAuction) MinBid() types.Coin {
+	if !a.HasBid() {
+		return a.Reserve
+	}
+	return types.Coin{Denom: a.Bid.Denom, Amount: a.Bid.Amount + 1}
+}
+
+// StrikeValue is the price of the whole Underlying at the Strike
+func (i OptionIssue) StrikeValue() (types.Coins, error) {
+	u, s := i.Underlying.Amount, i.Strike.Amount
+	if u <= 0 {
+		return nil, ErrInvalidUnderlying
+	}
+	if s <= 0 {
+		return nil, ErrInvalidStrike
+	}
+	if s > math.MaxInt64/u {
+		return nil, ErrStrikeOverflow
+	}
+	return types.Coins{{Denom: i.Strike.Denom, Amount: u * s}}, nil
+}
+
+// Terms are what the issuer bonds and the holder must trade for it.
+// A call bonds the Underlying for the strike value, a put bonds the
+// strike value for the Underlying
+func (i OptionIssue) Terms() (bond, trade types.Coins, err error) {
+	if i.Type != OptionCall && i.Type != OptionPut {
+		return nil, nil, ErrUnknownOptionType
+	}
+	if i.Underlying.Denom == "" {
+		return nil, nil, ErrInvalidUnderlying
+	}
+	if i.Strike.Denom == "" {
+		return nil, nil, ErrInvalidStrike
+	}
+	if i.Underlying.Denom == i.Strike.Denom {
+		return nil, nil, ErrSameDenom
+	}
+	value, err := i.StrikeValue()
+	if err != nil {
+		return nil, nil, err
+	}
+	underlying := types.Coins{i.Underlying}
+	if i.Type == OptionPut {
+		return value, underlying, nil
+	}
+	return underlying, value, nil
+}
+
+// share is the part of coins matching n units of the Underlying,
+// rounded down
+func (i OptionIssue) share(coins types.Coins, n int64) types.Coins {
+	var res types.Coins
+	total := big.NewInt(i.Underlying.Amount)
+	for _, coin := range coins {
+		amount := new(big.Int).Mul(big.NewInt(coin.Amount), big.NewInt(n))
+		amount.Quo(amount, total)
+		if amount.Sign() > 0 {
+			res = append(res, types.Coin{Denom: coin.Denom, Amount: amount.Int64()})
+		}
+	}
+	return res
 }
 
 func (i OptionIssue) IsExpired(h uint64) bool {
-	return (i.Expiration != 0 && h > i.Expiration)
+	return h > i.Expiration
+}
+
+// InExerciseWindow is true if the option may be exercised at height h
+func (i OptionIssue) InExerciseWindow(h uint64) bool {
+	return h >= i.ExerciseStart &&
+		(i.ExerciseEnd == 0 || h <= i.ExerciseEnd)
+}
+
+// CheckExerciseWindow makes sure the window can be reached before
+// the option expires
+func (i OptionIssue) CheckExerciseWindow() error {
+	if i.ExerciseEnd != 0 && i.ExerciseStart > i.ExerciseEnd {
+		return errors.Wrap(ErrExerciseWindow, "Starts after it ends")
+	}
+	if i.ExerciseStart > i.Expiration {
+		return errors.Wrap(ErrExerciseWindow, "Starts after expiration")
+	}
+	if i.ExerciseEnd > i.Expiration {
+		return errors.Wrap(ErrExerciseWindow, "Ends after expiration")
+	}
+	return nil
 }
 
 // Address is the ripemd160 hash of the constant part of the option
@@ -108,25 +354,65 @@ func (d OptionData) Bytes() []byte {
 	return wire.BinaryBytes(d)
 }
 
-// To buy, this option must be for sale, and the buyer must be
+// To buy, this option must be for sale at height h, and the buyer must be
 // listed (or an open sale)
-func (d OptionData) CanBuy(buyer []byte) bool {
-	return !d.Price.IsZero() &&
+func (d OptionData) CanBuy(buyer []byte, h uint64) bool {
+	return !d.Price.IsZero() && !d.IsSaleExpired(h) &&
 		(len(d.NewHolder) == 0 || bytes.Equal(buyer, d.NewHolder))
 }
 
+func (d OptionData) IsSaleExpired(h uint64) bool {
+	return d.SaleExpiration != 0 && h > d.SaleExpiration
+}
+
+// CanSell if the holder is not already auctioning it
 func (d OptionData) CanSell(buyer []byte) bool {
-	return bytes.Equal(buyer, d.Holder)
+	return bytes.Equal(buyer, d.Holder) && !d.IsAuction()
+}
+
+func (d OptionData) IsAuction() bool {
+	return d.Auction.End != 0
+}
+
+// CanBid if the auction is running and the bidder isn't the holder
+func (d OptionData) CanBid(bidder []byte, h uint64) bool {
+	return d.IsAuction() && h <= d.Auction.End &&
+		!bytes.Equal(bidder, d.Holder)
+}
+
+// Remaining is the units of the Underlying that can still be exercised
+func (d OptionData) Remaining() int64 {
+	return d.Underlying.Amount - d.Exercised
+}
+
+// ExerciseTerms are what the holder pays and receives for exercising
+// the next units of the option.  They are taken as the difference of the
+// cumulative shares, so the rounding never loses coins and the last
+// exercise settles exactly what is left
+func (d OptionData) ExerciseTerms(units int64) (pays, receives types.Coins) {
+	done := d.Exercised + units
+	pays = d.share(d.Trade, done).Minus(d.share(d.Trade, d.Exercised))
+	receives = d.share(d.Bond, done).Minus(d.share(d.Bond, d.Exercised))
+	return pays, receives
+}
+
+// RemainingBond is the part of the Bond not yet released by exercise
+func (d OptionData) RemainingBond() types.Coins {
+	return d.Bond.Minus(d.share(d.Bond, d.Exercised))
 }
 
+// CanExercise if held by addr, and not promised to a bidder
 func (d OptionData) CanExercise(addr []byte, h uint64) bool {
-	return bytes.Equal(addr, d.Holder) && !d.IsExpired(h)
+	return bytes.Equal(addr, d.Holder) && !d.IsExpired(h) &&
+		d.InExerciseWindow(h) && !d.IsAuction()
 }
 
 // CanDissolve if it is expired, or the holder, issue, and caller are the same
+// and it is not being auctioned
 func (d OptionData) CanDissolve(addr []byte, h uint64) bool {
 	return d.IsExpired(h) ||
-		(bytes.Equal(addr, d.Holder) && bytes.Equal(d.Holder, d.Issuer))
+		(bytes.Equal(addr, d.Holder) && bytes.Equal(d.Holder, d.Issuer) &&
+			!d.IsAuction())
 }
 
 func ParseOptionData(data []byte) (OptionData, error) {
@@ -4,6 +4,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/tendermint/basecoin/types"
 	cmn "github.com/tendermint/tmlibs/common"
@@ -21,6 +22,9 @@ func TestOptionData(t *testing.T) {
 			Issuer:     a,
 			Serial:     5,
 			Expiration: uint64(20),
+			Type:       OptionCall,
+			Underlying: bond[0],
+			Strike:     types.Coin{Amount: 5, Denom: "BTC"},
 			Bond:       bond,
 			Trade:      trade,
 		},
@@ -37,20 +41,26 @@ func TestOptionData(t *testing.T) {
 	// make sure the initial state is only issuer dissolve or sell
 	assert.True(data.CanSell(a))
 	assert.False(data.CanSell(b))
-	assert.False(data.CanBuy(a))
-	assert.False(data.CanBuy(b))
+	assert.False(data.CanBuy(a, 10))
+	assert.False(data.CanBuy(b, 10))
 	assert.True(data.CanDissolve(a, 10))
 	assert.False(data.CanDissolve(b, 10))
 	assert.True(data.CanDissolve(b, 50)) // b can dissolve if it ends up expired
 
 	// set a price and make sure anyone can buy
 	data.Price = price
-	assert.True(data.CanBuy(b))
-	assert.True(data.CanBuy(c))
+	assert.True(data.CanBuy(b, 10))
+	assert.True(data.CanBuy(c, 10))
 	// or a closed sale
 	data.NewHolder = b
-	assert.True(data.CanBuy(b))
-	assert.False(data.CanBuy(c))
+	assert.True(data.CanBuy(b, 10))
+	assert.False(data.CanBuy(c, 10))
+
+	// the offer can expire
+	data.SaleExpiration = 15
+	assert.True(data.CanBuy(b, 15))
+	assert.False(data.CanBuy(b, 16))
+	data.SaleExpiration = 0
 
 	// we complete the sale and make sure the address didn't change
 	data.Price = nil
@@ -60,7 +70,7 @@ func TestOptionData(t *testing.T) {
 	assert.Equal(addr, newAddr)
 
 	// now make sure the new buyer can sell and exercise
-	assert.False(data.CanBuy(c))
+	assert.False(data.CanBuy(c, 10))
 	assert.False(data.CanSell(a))
 	assert.True(data.CanSell(b))
 	assert.False(data.CanSell(c))
@@ -72,6 +82,226 @@ func TestOptionData(t *testing.T) {
 	assert.False(data.CanDissolve(b, 10))
 }
 
+func TestOptionTerms(t *testing.T) {
+	assert := assert.New(t)
+
+	eth := types.Coin{Amount: 100, Denom: "ETH"}
+	strike := types.Coin{Amount: 2, Denom: "BTC"}
+	value := types.Coins{{Amount: 200, Denom: "BTC"}}
+
+	// a call bonds the underlying and is exercised with the strike value
+	call := OptionIssue{Type: OptionCall, Underlying: eth, Strike: strike}
+	bond, trade, err := call.Terms()
+	assert.Nil(err)
+	assert.Equal(types.Coins{eth}, bond)
+	assert.Equal(value, trade)
+
+	// a put is the other way around
+	put := OptionIssue{Type: OptionPut, Underlying: eth, Strike: strike}
+	bond, trade, err = put.Terms()
+	assert.Nil(err)
+	assert.Equal(value, bond)
+	assert.Equal(types.Coins{eth}, trade)
+
+	// reject bad terms
+	bad := []OptionIssue{
+		{Type: OptionCall, Underlying: eth},
+		{Type: OptionCall, Underlying: eth, Strike: types.Coin{Amount: 2, Denom: "ETH"}},
+		{Type: OptionPut, Underlying: types.Coin{Amount: -100, Denom: "ETH"}, Strike: strike},
+		{Type: OptionPut, Underlying: types.Coin{Amount: 1 << 40, Denom: "ETH"},
+			Strike: types.Coin{Amount: 1 << 40, Denom: "BTC"}},
+		{Type: OptionType(7), Underlying: eth, Strike: strike},
+	}
+	for i, issue := range bad {
+		_, _, err = issue.Terms()
+		assert.NotNil(err, "%d", i)
+	}
+
+	// the cli names
+	for _, typ := range []OptionType{OptionCall, OptionPut} {
+		parsed, err := ParseOptionType(typ.String())
+		assert.Nil(err)
+		assert.Equal(typ, parsed)
+	}
+	_, err = ParseOptionType("straddle")
+	assert.NotNil(err)
+}
+
+func TestPartialExercise(t *testing.T) {
+	assert := assert.New(t)
+
+	data := OptionData{
+		OptionIssue: OptionIssue{
+			Type:       OptionCall,
+			Underlying: types.Coin{Amount: 3, Denom: "ETH"},
+			Strike:     types.Coin{Amount: 5, Denom: "BTC"},
+			Bond:       types.Coins{{Amount: 3, Denom: "ETH"}},
+			Trade:      types.Coins{{Amount: 15, Denom: "BTC"}},
+		},
+	}
+	assert.Equal(int64(3), data.Remaining())
+
+	pays, receives := data.ExerciseTerms(1)
+	assert.Equal(types.Coins{{Amount: 5, Denom: "BTC"}}, pays)
+	assert.Equal(types.Coins{{Amount: 1, Denom: "ETH"}}, receives)
+	data.Exercised = 1
+	assert.Equal(int64(2), data.Remaining())
+	assert.Equal(types.Coins{{Amount: 2, Denom: "ETH"}}, data.RemainingBond())
+
+	// the address doesn't change as it is exercised
+	addr := data.Address()
+	pays, receives = data.ExerciseTerms(2)
+	assert.Equal(types.Coins{{Amount: 10, Denom: "BTC"}}, pays)
+	assert.Equal(types.Coins{{Amount: 2, Denom: "ETH"}}, receives)
+	data.Exercised = 3
+	assert.Equal(addr, data.Address())
+	assert.Equal(int64(0), data.Remaining())
+	assert.True(data.RemainingBond().IsZero())
+
+	// rounding never loses coins over all the exercises
+	data.Bond = types.Coins{{Amount: 10, Denom: "ETH"}}
+	data.Exercised = 0
+	total := types.Coins{}
+	for i := 0; i < 3; i++ {
+		_, receives = data.ExerciseTerms(1)
+		total = total.Plus(receives)
+		data.Exercised++
+	}
+	assert.Equal(data.Bond, total)
+}
+
+func TestExerciseWindow(t *testing.T) {
+	assert := assert.New(t)
+	a := cmn.RandBytes(20)
+
+	// a european option only settles at maturity
+	data := OptionData{
+		OptionIssue: OptionIssue{
+			Expiration:    100,
+			ExerciseStart: 90,
+			ExerciseEnd:   100,
+		},
+		OptionHolder: OptionHolder{
+			Holder: a,
+		},
+	}
+	assert.Nil(data.CheckExerciseWindow())
+	assert.False(data.CanExercise(a, 50))
+	assert.True(data.CanExercise(a, 90))
+	assert.True(data.CanExercise(a, 100))
+	assert.False(data.CanExercise(a, 101))
+
+	// only a start, then it runs until expiration
+	data.ExerciseEnd = 0
+	assert.Nil(data.CheckExerciseWindow())
+	assert.False(data.CanExercise(a, 89))
+	assert.True(data.CanExercise(a, 95))
+	assert.False(data.CanExercise(a, 101))
+
+	// windows that can never be used
+	bad := []OptionIssue{
+		{ExerciseStart: 50, ExerciseEnd: 40},
+		{Expiration: 100, ExerciseStart: 101},
+		{Expiration: 100, ExerciseStart: 90, ExerciseEnd: 110},
+	}
+	for i, issue := range bad {
+		assert.NotNil(issue.CheckExerciseWindow(), "%d", i)
+	}
+}
+
+func TestAuctionState(t *testing.T) {
+	assert := assert.New(t)
+	a, b := cmn.RandBytes(20), cmn.RandBytes(20)
+
+	data := OptionData{
+		OptionIssue: OptionIssue{
+			Issuer:     a,
+			Expiration: 100,
+		},
+		OptionHolder: OptionHolder{
+			Holder: a,
+		},
+	}
+	assert.False(data.IsAuction())
+	assert.False(data.CanBid(b, 10))
+	assert.True(data.CanSell(a))
+
+	// while auctioned, the holder can't sell, exercise or dissolve
+	data.Auction = OptionAuction{
+		Reserve: types.Coin{Amount: 10, Denom: "ETH"},
+		End:     50,
+	}
+	assert.True(data.IsAuction())
+	assert.False(data.CanSell(a))
+	assert.False(data.CanExercise(a, 10))
+	assert.False(data.CanDissolve(a, 10))
+	assert.True(data.CanBid(b, 50))
+	assert.False(data.CanBid(a, 50))
+	assert.False(data.CanBid(b, 51))
+
+	// the reserve, and then a higher bid
+	assert.Equal(types.Coin{Amount: 10, Denom: "ETH"}, data.Auction.MinBid())
+	assert.Equal(types.Coins{{Amount: 10, Denom: "ETH"}}, data.AskingPrice())
+	data.Auction.Bidder = b
+	data.Auction.Bid = types.Coin{Amount: 15, Denom: "ETH"}
+	assert.Equal(types.Coin{Amount: 16, Denom: "ETH"}, data.Auction.MinBid())
+	assert.Equal([][]byte{OptionsForSaleKey}, data.SaleKeys())
+	assert.Equal(types.Coins{{Amount: 16, Denom: "ETH"}}, data.SaleEntry().Price)
+}
+
+func TestCreateOptionValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	eth := types.Coin{Amount: 100, Denom: "ETH"}
+	strike := types.Coin{Amount: 2, Denom: "BTC"}
+	good := CreateOptionTx{
+		Type:       OptionPut,
+		Underlying: eth,
+		Strike:     strike,
+		Expiration: 500,
+	}
+	assert.Nil(good.ValidateBasic())
+	assert.Nil(good.CheckHeight(400))
+
+	cases := []struct {
+		tx       CreateOptionTx
+		expected error
+	}{
+		{CreateOptionTx{Type: OptionType(3), Underlying: eth, Strike: strike}, ErrUnknownOptionType},
+		{CreateOptionTx{Strike: strike}, ErrInvalidUnderlying},
+		{CreateOptionTx{Underlying: types.Coin{Amount: 0, Denom: "ETH"}, Strike: strike}, ErrInvalidUnderlying},
+		{CreateOptionTx{Underlying: eth}, ErrInvalidStrike},
+		{CreateOptionTx{Underlying: eth, Strike: types.Coin{Amount: -2, Denom: "BTC"}}, ErrInvalidStrike},
+		{CreateOptionTx{Underlying: eth, Strike: types.Coin{Amount: 2, Denom: "ETH"}}, ErrSameDenom},
+		{CreateOptionTx{Underlying: types.Coin{Amount: 1 << 40, Denom: "ETH"},
+			Strike: types.Coin{Amount: 1 << 40, Denom: "BTC"}}, ErrStrikeOverflow},
+		{CreateOptionTx{Underlying: eth, Strike: strike, Expiration: 10, ExerciseEnd: 20}, ErrExerciseWindow},
+	}
+	for i, tc := range cases {
+		err := tc.tx.ValidateBasic()
+		assert.Equal(tc.expected, errors.Cause(err), "%d", i)
+	}
+
+	// the expiration must be ahead, but not too far
+	assert.Equal(ErrAlreadyExpired, good.CheckHeight(501))
+	good.Expiration = 400 + MaxExpirationHorizon
+	assert.Nil(good.CheckHeight(400))
+	good.Expiration++
+	assert.Equal(ErrExpirationHorizon, errors.Cause(good.CheckHeight(400)))
+	// and an option that never expires is beyond any horizon
+	good.Expiration = 0
+	assert.Equal(ErrExpirationHorizon, errors.Cause(good.CheckHeight(400)))
+
+	// a put must bond the strike value
+	_, err := good.CheckBond(types.Coins{{Amount: 199, Denom: "BTC"}})
+	assert.Equal(ErrInsufficientBond, errors.Cause(err))
+	_, err = good.CheckBond(nil)
+	assert.Equal(ErrInsufficientBond, errors.Cause(err))
+	remain, err := good.CheckBond(types.Coins{{Amount: 250, Denom: "BTC"}})
+	assert.Nil(err)
+	assert.Equal(types.Coins{{Amount: 50, Denom: "BTC"}}, remain)
+}
+
 func TestOptionsTxParse(t *testing.T) {
 	assert := assert.New(t)
 
@@ -83,8 +313,12 @@ func TestOptionsTxParse(t *testing.T) {
 
 	txs := []OptionsTx{
 		CreateOptionTx{
-			Expiration: 12345,
-			Trade:      trade,
+			Type:          OptionPut,
+			Underlying:    trade[1],
+			Strike:        trade[0],
+			Expiration:    12345,
+			ExerciseStart: 12000,
+			ExerciseEnd:   12345,
 		},
 		SellOptionTx{
 			Addr:      cmn.RandBytes(20),
@@ -92,19 +326,32 @@ func TestOptionsTxParse(t *testing.T) {
 			NewHolder: []byte{}, // note: nil is serialized/parsed as empty
 		},
 		SellOptionTx{
-			Addr:      cmn.RandBytes(20),
-			Price:     price,
-			NewHolder: cmn.RandBytes(20),
+			Addr:       cmn.RandBytes(20),
+			Price:      price,
+			NewHolder:  cmn.RandBytes(20),
+			Expiration: 300,
+		},
+		CancelSaleTx{
+			Addr: cmn.RandBytes(20),
 		},
 		BuyOptionTx{
 			Addr: cmn.RandBytes(20),
 		},
 		ExerciseOptionTx{
-			Addr: cmn.RandBytes(20),
+			Addr:  cmn.RandBytes(20),
+			Units: 25,
 		},
 		DisolveOptionTx{
 			Addr: cmn.RandBytes(20),
 		},
+		AuctionOptionTx{
+			Addr:    cmn.RandBytes(20),
+			Reserve: price[0],
+			End:     500,
+		},
+		BidOptionTx{
+			Addr: cmn.RandBytes(20),
+		},
 	}
 
 	// make sure all of them serialize and deserialize fine